@@ -1,60 +1,192 @@
 package loggeradapter
 
 import (
-	"archive/tar"
-	"compress/gzip"
+	"context"
+	"crypto/ed25519"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"math/rand"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
 	defaultArchiveTimeFormat = "2006-01-02T15-04-05"
-	defaultArchiveSuffix     = ".gz"
+	defaultArchiveSuffix     = ".tar.gz"
+	defaultArchivePattern    = "{dir}/{prefix}-{time}"
+	defaultCompressFormat    = "gz"
+	archiveTempSuffix        = ".tmp"
 )
 
 type archiver struct {
 	backupValue, archiveValue int
 	backupUnit, archiveUnit   string
-
-	backupTimeFormat                string
+	archiveGFS                gfsPolicy
+
+	backups, archives               *filePattern
+	codec                           Codec
+	level                           int
+	compress                        bool
+	checksum                        bool
+	logger                          string
+	signingKey                      ed25519.PrivateKey
+	keys                            KeyProvider
+	archiving                       bool
+	maxTotalSize, minFreeSpace      int64
+	pruneHandler                    PruneHandler
+	store                           ArchiveStore
+	local                           *LocalStore // the store when archives are built in place
+	stagingDir                      string
+	uploader                        ArchiveUploader
+	unshipped                       []string // archives of the pass to upload
+	chain                           chainHead
+	index                           fileIndex
 	isBackupNumber, isArchiveNumber bool
 	backupDuration, archiveDuration time.Duration
 
 	filename     string
-	millCh       chan bool
-	startArchive sync.Once
+	fileMode     os.FileMode
+	clock        Clock
+	location     *time.Location
+	errorHandler ErrorHandler
+	retry        retryPolicy
+	interval     time.Duration
+	jitter       time.Duration
+	stats        archiveStats
+
+	// ctx is passed to the store and the uploader, shutdown cancels it
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	millCh  chan bool
+	done    chan struct{}
+	stopped bool
+	millMu  sync.Mutex
+	millWg  sync.WaitGroup
+	runMu   sync.Mutex
 }
 
-func newArchiver(cfg Config) *archiver {
-	if cfg.Backup == "" || cfg.Archive == "" {
-		return nil
+// newArchiver returns nil when neither archiving, compression nor a size
+// limit is configured. With Compress every backup is compressed on its own
+// next to it, Backup then keeps the newest backups uncompressed and Archive
+// prunes the compressed ones.
+func newArchiver(cfg Config, opts options) (*archiver, error) {
+	archiving := cfg.Compress || (cfg.Backup != "" && cfg.Archive != "")
+	if !archiving && cfg.MaxTotalSize == "" && cfg.MinFreeSpace == "" {
+		return nil, nil
 	}
 
-	backupValue, backupUnit, err := ParseExpression(cfg.Backup)
-	if err != nil {
-		panic(fmt.Sprintf("Parse backup expression failed. error: %v", err))
+	var (
+		backupValue, archiveValue int
+		backupUnit, archiveUnit   string
+		err                       error
+	)
+
+	if cfg.Backup != "" {
+		if backupValue, backupUnit, err = ParseExpression(cfg.Backup); err != nil {
+			return nil, &ConfigError{Field: "Backup", Value: cfg.Backup, Reason: err.Error()}
+		}
+	}
+	var archiveGFS gfsPolicy
+	if IsGFS(cfg.Archive) {
+		if archiveGFS, err = parseGFS(cfg.Archive); err != nil {
+			return nil, &ConfigError{Field: "Archive", Value: cfg.Archive, Reason: err.Error()}
+		}
+	} else if cfg.Archive != "" {
+		if archiveValue, archiveUnit, err = ParseExpression(cfg.Archive); err != nil {
+			return nil, &ConfigError{Field: "Archive", Value: cfg.Archive, Reason: err.Error()}
+		}
 	}
 
-	archiveValue, archiveUnit, err := ParseExpression(cfg.Archive)
+	maxTotalSize, err := parseSizeLimit("MaxTotalSize", cfg.MaxTotalSize)
 	if err != nil {
-		panic(fmt.Sprintf("Parse archive expression failed. error: %v", err))
+		return nil, err
+	}
+	minFreeSpace, err := parseSizeLimit("MinFreeSpace", cfg.MinFreeSpace)
+	if err != nil {
+		return nil, err
 	}
 
-	rp := &archiver{
-		filename:         cfg.Filename,
-		backupValue:      backupValue,
-		backupUnit:       backupUnit,
-		archiveValue:     archiveValue,
-		archiveUnit:      archiveUnit,
-		backupTimeFormat: cfg.timeFormat,
+	codec, ok := LookupCodec(archiveFormatOrDefault(cfg.ArchiveFormat, cfg.Compress))
+	if !ok {
+		return nil, &ConfigError{Field: "ArchiveFormat", Value: cfg.ArchiveFormat, Reason: "unknown archive format"}
+	}
+
+	if opts.keys != nil {
+		codec = encryptedCodec{Codec: codec, keys: opts.keys}
+	}
+
+	var archives *filePattern
+	if cfg.Compress {
+		// compressed backups keep the name of the backup, e.g. app-2024-01-01T10.log.gz
+		compressed := *cfg.backupPattern
+		if cfg.ArchiveDir != "" {
+			compressed.root = filepath.Clean(cfg.ArchiveDir)
+		}
+		archives = &compressed
+	} else {
+		// {dir} of archive names is the archive directory
+		filename := cfg.Filename
+		if cfg.ArchiveDir != "" {
+			filename = filepath.Join(cfg.ArchiveDir, filepath.Base(cfg.Filename))
+		}
+		archives, err = newFilePattern(archivePatternOrDefault(cfg.ArchivePattern), filename,
+			defaultArchiveTimeFormat, opts.location)
+		if err != nil {
+			return nil, &ConfigError{Field: "ArchivePattern", Value: cfg.ArchivePattern, Reason: err.Error()}
+		}
 	}
+	archives.suffix = codec.Suffix()
+	archives.suffixes = codecSuffixes
+
+	store := opts.archiveStore
+	if store == nil {
+		store = &LocalStore{dir: archives.root, depth: archives.depth, mode: opts.fileMode}
+	}
+	local, _ := store.(*LocalStore)
+	if opts.archiveUploader != nil && local == nil {
+		return nil, &ConfigError{Field: "ArchiveUploader", Reason: "uploads ship archives of the local store, they can't be used with another ArchiveStore"}
+	}
+
+	rp := &archiver{
+		filename:     cfg.Filename,
+		backupValue:  backupValue,
+		backupUnit:   backupUnit,
+		archiveValue: archiveValue,
+		archiveUnit:  archiveUnit,
+		archiveGFS:   archiveGFS,
+		backups:      cfg.backupPattern,
+		archives:     archives,
+		codec:        codec,
+		level:        cfg.ArchiveLevel,
+		compress:     cfg.Compress,
+		checksum:     cfg.ArchiveChecksum,
+		logger:       filepath.Base(cfg.Filename),
+		signingKey:   opts.signingKey,
+		keys:         opts.keys,
+		archiving:    archiving,
+		maxTotalSize: maxTotalSize,
+		minFreeSpace: minFreeSpace,
+		pruneHandler: opts.pruneHandler,
+		store:        store,
+		local:        local,
+		stagingDir:   filepath.Join(filepath.Dir(cfg.Filename), "."+filepath.Base(cfg.Filename)+".staging"),
+		uploader:     opts.archiveUploader,
+		fileMode:     opts.fileMode,
+		clock:        opts.clock,
+		location:     opts.location,
+		errorHandler: opts.errorHandler,
+		retry:        opts.archiveRetry,
+		interval:     opts.archiveInterval,
+		jitter:       opts.archiveJitter,
+	}
+	rp.ctx, rp.cancel = context.WithCancel(context.Background())
 
 	if backupUnit == "" && backupValue > 0 {
 		rp.isBackupNumber = true
@@ -68,7 +200,24 @@ func newArchiver(cfg Config) *archiver {
 		rp.setArchiveDuration()
 	}
 
-	return rp
+	return rp, nil
+}
+
+func archiveFormatOrDefault(format string, compress bool) string {
+	if format != "" {
+		return format
+	}
+	if compress {
+		return defaultCompressFormat
+	}
+	return defaultArchiveFormat
+}
+
+func archivePatternOrDefault(pattern string) string {
+	if pattern == "" {
+		return defaultArchivePattern
+	}
+	return pattern
 }
 
 func (a *archiver) setBackupDuration() {
@@ -155,143 +304,562 @@ func (a *archiver) setArchiveDuration() {
 	}
 }
 
+func (a *archiver) now() time.Time {
+	return a.clock.Now().In(a.location)
+}
+
+// start runs the mill goroutine and its first pass, which archives what
+// accumulated while the writer wasn't running.
+func (a *archiver) start() {
+	a.millMu.Lock()
+	defer a.millMu.Unlock()
+
+	if a.stopped || a.millCh != nil {
+		return
+	}
+	a.millCh = make(chan bool, 1)
+	a.done = make(chan struct{})
+	a.millCh <- true
+	a.millWg.Add(1)
+	go a.mill()
+}
+
+// archive nudges the mill goroutine, nudges arriving during a pass are
+// coalesced into the next one.
 func (a *archiver) archive() {
-	a.startArchive.Do(func() {
-		a.millCh = make(chan bool, 1)
-
-		go func() {
-			for range a.millCh {
-				if err := a.runArchive(); err != nil {
-					panic(fmt.Sprintf("Archive logs failed, error: %v", err))
-				}
-			}
-		}()
-	})
+	a.millMu.Lock()
+	defer a.millMu.Unlock()
 
+	if a.stopped || a.millCh == nil {
+		return
+	}
 	select {
 	case a.millCh <- true:
 	default:
 	}
 }
 
+// mill runs archive passes when nudged and on its own schedule, so backups
+// keep being archived and pruned while nothing is written.
+func (a *archiver) mill() {
+	defer a.millWg.Done()
+
+	var (
+		rescan bool
+		tick   <-chan time.Time
+		timer  *time.Timer
+		rnd    = rand.New(rand.NewSource(time.Now().UnixNano() ^ int64(os.Getpid())))
+	)
+	if a.interval > 0 {
+		timer = time.NewTimer(a.nextInterval(rnd))
+		defer timer.Stop()
+		tick = timer.C
+	}
+
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-a.millCh:
+			rescan = false
+		case <-tick:
+			// scheduled passes pick up files changed behind the writer's back
+			rescan = true
+			timer.Reset(a.nextInterval(rnd))
+		}
+
+		if err := a.runArchiveWithRetry(rescan); err != nil {
+			a.errorHandler("archive", err)
+		}
+	}
+}
+
+// nextInterval returns the interval moved by a random jitter.
+func (a *archiver) nextInterval(rnd *rand.Rand) time.Duration {
+	if a.jitter <= 0 {
+		return a.interval
+	}
+	d := a.interval - a.jitter + time.Duration(rnd.Int63n(int64(2*a.jitter)+1))
+	if d <= 0 {
+		return time.Millisecond
+	}
+	return d
+}
+
+// runArchiveWithRetry runs an archive pass, retrying failed passes with
+// exponential backoff until the retry policy gives up or the archiver stops.
+func (a *archiver) runArchiveWithRetry(rescan bool) error {
+	backoff := a.retry.backoff
+
+	for attempt := 1; ; attempt++ {
+		err := a.countArchive(a.runPass(rescan))
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= a.retry.attempts {
+			atomic.AddInt64(&a.stats.failures, 1)
+			return err
+		}
+		atomic.AddInt64(&a.stats.retries, 1)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-a.done:
+			timer.Stop()
+			atomic.AddInt64(&a.stats.failures, 1)
+			return err
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if a.retry.maxBackoff > 0 && backoff > a.retry.maxBackoff {
+			backoff = a.retry.maxBackoff
+		}
+	}
+}
+
+func (a *archiver) countArchive(err error) error {
+	atomic.AddInt64(&a.stats.passes, 1)
+	if err != nil {
+		atomic.AddInt64(&a.stats.errors, 1)
+	}
+	return err
+}
+
+// shutdown stops the mill goroutine and waits for an in-flight archive pass,
+// then runs a final pass when requested. Store and upload calls still running
+// when ctx is done are cancelled.
+func (a *archiver) shutdown(ctx context.Context, finalPass bool) error {
+	a.millMu.Lock()
+	if a.stopped {
+		a.millMu.Unlock()
+		return nil
+	}
+	a.stopped = true
+	if a.done != nil {
+		close(a.done)
+	}
+	a.millMu.Unlock()
+
+	finished := make(chan error, 1)
+	go func() {
+		a.millWg.Wait()
+		if finalPass {
+			finished <- a.countArchive(a.runArchive())
+			return
+		}
+		finished <- nil
+	}()
+
+	defer a.cancel()
+	select {
+	case err := <-finished:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runArchive runs a pass on a freshly loaded index.
 func (a *archiver) runArchive() error {
+	return a.runPass(true)
+}
+
+// runPass runs an archive pass, rescan reloads the index of backups and
+// archives first. A failed pass leaves the index to be reloaded.
+func (a *archiver) runPass(rescan bool) (err error) {
+	a.runMu.Lock()
+	defer a.runMu.Unlock()
+
+	if rescan {
+		a.index.invalidate()
+	}
+	defer func() {
+		if err != nil {
+			a.index.invalidate()
+		}
+	}()
+
+	if a.archiving {
+		err := a.archiveBackups()
+		// archives written before a failure are shipped all the same
+		if a.uploader != nil {
+			if shipErr := a.ship(); err == nil {
+				err = shipErr
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return a.pruneBySize()
+}
+
+func (a *archiver) archiveBackups() error {
 	logFiles, err := a.filterBackupFiles()
 	if err != nil {
 		return err
 	}
 
+	// backups removed since the index was loaded are dropped from it
+	existing := logFiles[:0]
+	for _, f := range logFiles {
+		if fileExists(f.path) {
+			existing = append(existing, f)
+		} else {
+			a.forgetBackup(f.path)
+		}
+	}
+	logFiles = existing
+
 	if len(logFiles) == 0 {
 		return nil
 	}
 
-	dir := filepath.Dir(a.filename)
-	gzipFilename := a.getGzipFilename()
-
-	err = archiveCompress(gzipFilename, func(w *tar.Writer) error {
-		closeFile := func(f *os.File) error {
-			return f.Close()
+	// compressed backups and codecs holding a single file get an archive per backup
+	batches := [][]logInfo{logFiles}
+	if a.compress || !a.codec.MultiFile() {
+		batches = batches[:0]
+		for _, f := range logFiles {
+			batches = append(batches, []logInfo{f})
 		}
+	}
 
-		for _, f := range logFiles {
-			filename := filepath.Join(dir, f.Name())
+	exists, err := a.archiveExists()
+	if err != nil {
+		return err
+	}
 
-			logFile, err := os.Open(filename)
-			if err != nil {
-				return err
-			}
+	// the oldest backups are archived first to keep the chain in order
+	for i := len(batches) - 1; i >= 0; i-- {
+		if err = a.archiveBatch(batches[i], exists); err != nil {
+			return err
+		}
+	}
 
-			header := &tar.Header{
-				Name: f.Name(),
-				Mode: int64(f.Mode()),
-				Size: f.Size(),
-			}
+	gzipFiles, _ := a.filterGzipFiles()
+	for _, f := range gzipFiles {
+		_ = a.pruneArchive(f.rel)
+	}
+	if len(gzipFiles) > 0 {
+		return a.dropPruned()
+	}
 
-			if err = w.WriteHeader(header); err != nil {
-				_ = closeFile(logFile)
-				return err
-			}
+	return nil
+}
+
+func (a *archiver) archiveBatch(logFiles []logInfo, exists func(name string) bool) error {
+	name := a.archiveName(exists)
+	if a.compress {
+		name = logFiles[0].rel + a.codec.Suffix()
+	}
+	gzipFilename := a.stagingPath(name)
 
-			if _, err = io.Copy(w, logFile); err != nil {
-				_ = closeFile(logFile)
+	manifest := &Manifest{Version: manifestVersion, Created: a.now()}
+	err := archiveCompress(gzipFilename, a.fileMode, a.codec, a.level, func(w ArchiveWriter) error {
+		for _, f := range logFiles {
+			logFile, err := os.Open(f.path)
+			if err != nil {
 				return err
 			}
 
-			if err = closeFile(logFile); err != nil {
+			mf, err := addMember(w, a.memberName(f), f.FileInfo, logFile)
+			_ = logFile.Close()
+			if err != nil {
 				return err
 			}
-
-			_ = os.Remove(filename)
+			manifest.Files = append(manifest.Files, mf)
 		}
-		return nil
+		if err := a.linkManifest(manifest); err != nil {
+			return err
+		}
+		return addManifest(w, a.codec, gzipFilename, manifest, a.fileMode)
 	})
 	if err != nil {
+		removeSidecars(gzipFilename)
 		return err
 	}
 
-	gzipFiles, _ := a.filterGzipFiles()
-	for _, f := range gzipFiles {
-		_ = os.Remove(filepath.Join(dir, f.Name()))
+	// the backups are only removed once the archive is in the store
+	if err = a.finishArchive(name, gzipFilename, a.codec, manifest); err != nil {
+		return err
+	}
+
+	for _, f := range logFiles {
+		_ = os.Remove(f.path)
+		a.forgetBackup(f.path)
+		a.backups.removeEmptyDirs(f.path)
 	}
+	return nil
+}
 
+// finishArchive checksums the archive written to path, checks it reads back
+// in full, puts it into the store and makes it the head of the chain, the
+// archive is removed when any of it fails. Archives without a manifest, left
+// by older versions, aren't verified.
+func (a *archiver) finishArchive(name, path string, codec Codec, manifest *Manifest) error {
+	var err error
+	if a.checksum {
+		err = writeChecksum(path, a.fileMode)
+	}
+	var archived os.FileInfo
+	if err == nil {
+		archived, err = os.Stat(path)
+	}
+	if err == nil && manifest != nil {
+		_, err = verifyArchive(path, codec)
+	}
+	if err == nil {
+		err = a.upload(name, path)
+	}
+	if err == nil {
+		err = a.advanceChain(name, path, manifest)
+	}
+	if a.local == nil || err != nil {
+		_ = removeArchive(path)
+	}
+	if err != nil {
+		return err
+	}
+	a.addArchive(name, archived.Size(), archived.ModTime())
+
+	if a.uploader != nil {
+		a.unshipped = append(a.unshipped, name)
+	}
 	return nil
 }
 
-func archiveCompress(gzipFilename string, r func(w *tar.Writer) error) error {
-	gzipFile, err := openFile(gzipFilename)
+func (a *archiver) memberName(f logInfo) string {
+	if a.compress {
+		return f.Name()
+	}
+	return f.rel
+}
+
+// addMember adds the content of r to the archive and returns its manifest entry.
+func addMember(w ArchiveWriter, name string, info os.FileInfo, r io.Reader) (ManifestFile, error) {
+	d := newDigestReader(r)
+	if err := w.Add(name, info, d); err != nil {
+		return ManifestFile{}, err
+	}
+	if d.size != info.Size() {
+		return ManifestFile{}, fmt.Errorf("%s changed while archiving: read %d of %d bytes", name, d.size, info.Size())
+	}
+	return d.file(name, info.ModTime()), nil
+}
+
+// archiveCompress builds the archive under a temporary name, syncs it and
+// renames it into place, so a crash never leaves a truncated archive behind.
+func archiveCompress(gzipFilename string, mode os.FileMode, codec Codec, level int,
+	r func(w ArchiveWriter) error) (err error) {
+	tmpFilename := gzipFilename + archiveTempSuffix
+
+	if err = os.MkdirAll(filepath.Dir(gzipFilename), os.ModePerm); err != nil {
+		return err
+	}
+	gzipFile, err := os.OpenFile(tmpFilename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
 	if err != nil {
 		return err
 	}
 	defer func() {
-		if err := gzipFile.Close(); err != nil {
-			_ = os.Remove(gzipFilename)
+		if err != nil {
+			_ = gzipFile.Close()
+			_ = os.Remove(tmpFilename)
 		}
 	}()
 
-	gzipWriter := gzip.NewWriter(gzipFile)
-	defer func() {
-		//gzipWriter.Flush()
-		if err := gzipWriter.Close(); err != nil {
-			_ = os.Remove(gzipFilename)
-		}
-	}()
+	archiveWriter, err := codec.NewWriter(gzipFile, level)
+	if err != nil {
+		return err
+	}
+	if err = r(archiveWriter); err != nil {
+		_ = archiveWriter.Close()
+		return err
+	}
+	if err = archiveWriter.Close(); err != nil {
+		return err
+	}
+	if err = gzipFile.Sync(); err != nil {
+		return err
+	}
+	if err = gzipFile.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpFilename, gzipFilename); err != nil {
+		return err
+	}
 
-	tarWriter := tar.NewWriter(gzipWriter)
-	defer func() {
-		//tarWriter.Flush()
-		if err := tarWriter.Close(); err != nil {
-			_ = os.Remove(gzipFilename)
-		}
-	}()
+	syncDir(filepath.Dir(gzipFilename))
+	return nil
+}
 
-	return r(tarWriter)
+// syncDir persists a rename, it's not supported everywhere so errors are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
 }
 
-func (a *archiver) filterBackupFiles() ([]logInfo, error) {
-	files, err := os.ReadDir(filepath.Dir(a.filename))
+// recover handles the temporary archives left by an interrupted pass. A
+// complete one whose backups are all still there is renamed into place and
+// finished like the archives of a pass before its backups are removed, any
+// other is removed since its backups are intact.
+func (a *archiver) recover() error {
+	a.runMu.Lock()
+	defer a.runMu.Unlock()
+
+	// archives staged for a remote store are uploaded before their backups
+	// are removed, so the backups of leftovers are still there
+	if a.local == nil {
+		return os.RemoveAll(a.stagingDir)
+	}
+
+	temps := *a.archives
+	temps.root = a.local.dir
+	temps.suffixes = func() []string {
+		suffixes := codecSuffixes()
+		for i := range suffixes {
+			suffixes[i] += archiveTempSuffix
+		}
+		return suffixes
+	}
+
+	tmpFiles, err := temps.scan()
 	if err != nil {
-		return nil, fmt.Errorf("can't read log file directory: %s", err)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
 	}
-	var logFiles []logInfo
 
-	prefix, ext := prefixAndExt(a.filename)
+	// the oldest first, so the chain ends at the newest
+	for i := len(tmpFiles) - 1; i >= 0; i-- {
+		f := tmpFiles[i]
+		gzipFilename := strings.TrimSuffix(f.path, archiveTempSuffix)
 
-	for _, f := range files {
-		if f.IsDir() {
+		sources, ok := a.recoverSources(f.path, gzipFilename)
+		if !ok || fileExists(gzipFilename) {
+			if err = os.Remove(f.path); err != nil {
+				return err
+			}
+			if !fileExists(gzipFilename) {
+				removeSidecars(gzipFilename)
+			}
 			continue
 		}
 
-		fileInfo, err := f.Info()
+		if err = os.Rename(f.path, gzipFilename); err != nil {
+			return err
+		}
+		syncDir(filepath.Dir(gzipFilename))
+
+		codec, err := archiveCodec(gzipFilename, a.keys)
+		if err != nil {
+			return err
+		}
+		manifest, err := readArchiveManifest(gzipFilename, codec)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		name, err := filepath.Rel(a.local.dir, gzipFilename)
+		if err != nil {
+			return err
+		}
+		if err = a.finishArchive(filepath.ToSlash(name), gzipFilename, codec, manifest); err != nil {
+			return err
+		}
+
+		for _, source := range sources {
+			_ = os.Remove(source)
+			a.backups.removeEmptyDirs(source)
+		}
+	}
+
+	return nil
+}
+
+// recoverSources returns the backups archived in a temporary archive, it
+// reports false unless the archive is complete and matches them.
+func (a *archiver) recoverSources(tmpFilename, gzipFilename string) ([]string, bool) {
+	codec, err := archiveCodec(gzipFilename, a.keys)
+	if err != nil {
+		return nil, false
+	}
+
+	file, err := os.Open(tmpFilename)
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+
+	r, err := codec.NewReader(file)
+	if err != nil {
+		return nil, false
+	}
+	defer r.Close()
+
+	var sources []string
+	for {
+		name, mr, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
 		if err != nil {
+			return nil, false
+		}
+		if name == ManifestName && codec.MultiFile() {
 			continue
 		}
+		if name == "" && !codec.MultiFile() {
+			m, err := readManifestSidecar(openLocal, gzipFilename, codec)
+			if err != nil {
+				return nil, false
+			}
+			name = singleMemberName(m)
+		}
 
-		// 根据备份策略确定要压缩那些文件
-		if t, err := a.timeFromLogFilename(f.Name(), prefix, ext); err == nil {
-			logFiles = append(logFiles, logInfo{t, fileInfo})
+		// compressed backups are named after the backup, archives hold
+		// backups by their path relative to the backup directory
+		rel := name
+		if a.compress {
+			archiveRel, err := filepath.Rel(a.local.dir, gzipFilename)
+			if err != nil {
+				return nil, false
+			}
+			rel = strings.TrimSuffix(filepath.ToSlash(archiveRel), codec.Suffix())
+		}
+		if _, _, ok := a.backups.parse(rel); !ok {
+			return nil, false
+		}
+		source := filepath.Join(a.backups.root, filepath.FromSlash(rel))
+
+		n, err := io.Copy(io.Discard, mr)
+		if err != nil {
+			return nil, false
 		}
+		if fi, err := os.Stat(source); err != nil || fi.Size() != n {
+			return nil, false
+		}
+		sources = append(sources, source)
+	}
+
+	return sources, len(sources) > 0
+}
+
+func (a *archiver) filterBackupFiles() ([]logInfo, error) {
+	// 根据备份策略确定要压缩那些文件
+	logFiles, err := a.indexedBackups()
+	if err != nil {
+		return nil, err
 	}
 
-	sort.Sort(byFormatTime(logFiles))
+	if a.compress {
+		return a.filterCompressFiles(logFiles), nil
+	}
 
 	if a.isBackupNumber {
 		if len(logFiles) >= a.backupValue {
@@ -301,7 +869,7 @@ func (a *archiver) filterBackupFiles() ([]logInfo, error) {
 	}
 
 	var filteredLogFiles []logInfo
-	now := time.Now()
+	now := a.now()
 	end := now.Add(-a.backupDuration)
 
 	for _, f := range logFiles {
@@ -313,29 +881,47 @@ func (a *archiver) filterBackupFiles() ([]logInfo, error) {
 	return filteredLogFiles, nil
 }
 
-func (a *archiver) filterGzipFiles() ([]logInfo, error) {
-	files, err := os.ReadDir(filepath.Dir(a.filename))
-	if err != nil {
-		return nil, fmt.Errorf("can't read log file directory: %s", err)
+// filterCompressFiles returns the backups to compress: all of them without
+// Backup, the ones beyond the newest Backup ones, or the ones older than Backup.
+func (a *archiver) filterCompressFiles(logFiles []logInfo) []logInfo {
+	if a.backupValue == 0 {
+		return logFiles
 	}
-	var gzipFiles []logInfo
 
-	for _, f := range files {
-		if f.IsDir() {
-			continue
+	if a.isBackupNumber {
+		if len(logFiles) > a.backupValue {
+			return logFiles[a.backupValue:]
 		}
+		return nil
+	}
 
-		fileInfo, err := f.Info()
-		if err != nil {
-			continue
-		}
+	var filteredLogFiles []logInfo
+	end := a.now().Add(-a.backupDuration)
 
-		if t, err := a.timeFromGzipFilename(fileInfo.Name()); err == nil {
-			gzipFiles = append(gzipFiles, logInfo{t, fileInfo})
+	for _, f := range logFiles {
+		if f.timestamp.Before(end) {
+			filteredLogFiles = append(filteredLogFiles, f)
 		}
 	}
 
-	sort.Sort(byFormatTime(gzipFiles))
+	return filteredLogFiles
+}
+
+func (a *archiver) filterGzipFiles() ([]logInfo, error) {
+	// compressed backups are kept forever without Archive
+	if a.archiveValue == 0 && a.archiveGFS == nil {
+		return nil, nil
+	}
+
+	// only the archives of this logger are matched by its pattern
+	gzipFiles, err := a.indexedArchives()
+	if err != nil {
+		return nil, err
+	}
+
+	if a.archiveGFS != nil {
+		return a.archiveGFS.expired(gzipFiles, a.now()), nil
+	}
 
 	if a.isArchiveNumber {
 		if len(gzipFiles) >= a.archiveValue {
@@ -345,7 +931,7 @@ func (a *archiver) filterGzipFiles() ([]logInfo, error) {
 	}
 
 	var filteredGzipFiles []logInfo
-	now := time.Now()
+	now := a.now()
 	end := now.Add(-a.archiveDuration)
 
 	for _, f := range gzipFiles {
@@ -357,38 +943,20 @@ func (a *archiver) filterGzipFiles() ([]logInfo, error) {
 	return filteredGzipFiles, nil
 }
 
-func (a *archiver) getGzipFilename() string {
-	return filepath.Join(filepath.Dir(a.filename),
-		fmt.Sprintf("%s%s", time.Now().Format(defaultArchiveTimeFormat), defaultArchiveSuffix))
-}
-
-func (a *archiver) timeFromLogFilename(filename, prefix, ext string) (time.Time, error) {
-	if !strings.HasPrefix(filename, prefix+"-") {
-		return time.Time{}, errors.New("mismatched prefix")
-	}
-	if !strings.HasSuffix(filename, ext) {
-		return time.Time{}, errors.New("mismatched extension")
-	}
-	ts := filename[len(prefix+"-") : len(filename)-len(ext)]
-	return time.Parse(a.backupTimeFormat, ts)
-}
-
-func (a *archiver) timeFromGzipFilename(filename string) (time.Time, error) {
-	if !strings.HasSuffix(filename, defaultArchiveSuffix) {
-		return time.Time{}, errors.New("mismatched extension")
-	}
-	ts := filename[:len(filename)-len(defaultArchiveSuffix)]
-	return time.Parse(defaultArchiveTimeFormat, ts)
-}
-
 type logInfo struct {
 	timestamp time.Time
+	seq       int
+	path      string // path of the file, it may be in a subdirectory
+	rel       string // slash separated path relative to the scanned directory
 	os.FileInfo
 }
 
 type byFormatTime []logInfo
 
 func (b byFormatTime) Less(i, j int) bool {
+	if b[i].timestamp.Equal(b[j].timestamp) {
+		return b[i].seq > b[j].seq
+	}
 	return b[i].timestamp.After(b[j].timestamp)
 }
 
@@ -0,0 +1,483 @@
+package loggeradapter
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestArchiveErrorHandler(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+	clock := ClockFunc(func() time.Time { return now })
+
+	// A file in place of the archive directory makes every archive pass fail.
+	if err := os.WriteFile(filepath.Join(dir, "blocker"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu     sync.Mutex
+		errs   []error
+		called = make(chan struct{}, 1)
+	)
+	handler := func(op string, err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+		if op != "archive" {
+			t.Errorf("unexpected op %s", op)
+		}
+		select {
+		case called <- struct{}{}:
+		default:
+		}
+	}
+
+	w, err := NewWriter(Config{
+		Filename:       filepath.Join(dir, "app.log"),
+		Rotation:       "10b",
+		Backup:         "1",
+		Archive:        "5",
+		ArchivePattern: "blocker/{prefix}-{time}",
+		ErrorHandler:   handler,
+	}, WithClock(clock), WithArchiveRetry(3, time.Millisecond, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// the pass at startup finds nothing to archive
+	waitFor(t, "the pass at startup", func() bool { return w.Stats().ArchivePasses == 1 })
+	if _, err = w.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-called:
+	case <-time.After(5 * time.Second):
+		t.Fatal("error handler was not called")
+	}
+
+	stats := w.Stats()
+	if stats.ArchiveFailures != 1 || stats.ArchiveRetries != 2 || stats.ArchiveErrors != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var pathErr *os.PathError
+	if len(errs) != 1 || !errors.As(errs[0], &pathErr) {
+		t.Errorf("unexpected errors %v", errs)
+	}
+}
+
+func TestArchiveRetentionPerLogger(t *testing.T) {
+	dir := t.TempDir()
+
+	others := []string{
+		"access-2024-01-01T10-00-00.tar.gz",
+		"access-2024-01-02T10-00-00.tar.gz",
+		"2024-01-03T10-00-00.gz",
+	}
+	for _, name := range others {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mu sync.Mutex
+	now := time.Date(2024, 1, 5, 10, 0, 0, 0, time.Local)
+	clock := ClockFunc(func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(time.Second)
+		return now
+	})
+
+	w, err := NewWriter(Config{
+		Filename: filepath.Join(dir, "app.log"),
+		Rotation: "10b",
+		Backup:   "1",
+		Archive:  "1",
+	}, WithClock(clock), WithArchiveOnShutdown())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err = w.Write([]byte("0123456789")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range others {
+		if _, err = os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("archive of another logger was removed: %v", err)
+		}
+	}
+
+	archives, _ := filepath.Glob(filepath.Join(dir, "app-*"+defaultArchiveSuffix))
+	if len(archives) != 1 {
+		t.Errorf("expected one archive of app to be retained, got %v", archives)
+	}
+}
+
+func TestArchiveCompressBackups(t *testing.T) {
+	dir := t.TempDir()
+
+	var mu sync.Mutex
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+	clock := ClockFunc(func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	})
+
+	w, err := NewWriter(Config{
+		Filename: filepath.Join(dir, "app.log"),
+		Rotation: "1h",
+		Backup:   "1",
+		Archive:  "1",
+		Compress: true,
+	}, WithClock(clock), WithArchiveOnShutdown())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err = w.Write([]byte("hello\n")); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		now = now.Add(time.Hour)
+		mu.Unlock()
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "app-*"))
+	for i := range files {
+		files[i] = filepath.Base(files[i])
+	}
+	want := []string{"app-2024-01-01T11.log.gz", "app-2024-01-01T11.log.gz" + manifestSuffix, "app-2024-01-01T12.log"}
+	if len(files) != len(want) || files[0] != want[0] || files[1] != want[1] || files[2] != want[2] {
+		t.Fatalf("expected %v, got %v", want, files)
+	}
+
+	f, err := os.Open(filepath.Join(dir, want[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	codec, _ := LookupCodec("gz")
+	r, err := codec.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, content, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(content); name != "app-2024-01-01T11.log" || string(b) != "hello\n" {
+		t.Errorf("unexpected member %s: %q", name, b)
+	}
+}
+
+func TestArchiveRecover(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 1, 14, 0, 0, 0, time.Local)
+
+	backups := []string{"app-2024-01-01T10.log", "app-2024-01-01T11.log"}
+	for _, name := range backups {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a complete archive of both backups interrupted before its rename
+	codec, _ := LookupCodec("tar.gz")
+	complete := filepath.Join(dir, "app-2024-01-01T12-00-00.tar.gz")
+	err := archiveCompress(complete, 0644, codec, 0, func(w ArchiveWriter) error {
+		for _, name := range backups {
+			fi, _ := os.Stat(filepath.Join(dir, name))
+			if err := w.Add(name, fi, bytes.NewBufferString(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Rename(complete, complete+archiveTempSuffix); err != nil {
+		t.Fatal(err)
+	}
+
+	// a truncated one
+	truncated := filepath.Join(dir, "app-2024-01-01T13-00-00.tar.gz")
+	if err = os.WriteFile(truncated+archiveTempSuffix, []byte{0x1f, 0x8b}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewWriter(Config{
+		Filename: filepath.Join(dir, "app.log"),
+		Rotation: "1h",
+		Backup:   "1d",
+		Archive:  "10",
+	}, WithClock(ClockFunc(func() time.Time { return now })))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = os.Stat(complete); err != nil {
+		t.Errorf("expected the complete archive to be renamed into place: %v", err)
+	}
+	for _, name := range []string{complete + archiveTempSuffix, truncated, truncated + archiveTempSuffix} {
+		if _, err = os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", filepath.Base(name), err)
+		}
+	}
+	for _, name := range backups {
+		if _, err = os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("expected archived backup %s to be removed, got %v", name, err)
+		}
+	}
+}
+
+func TestArchiveRecoverFinishes(t *testing.T) {
+	s, srv := newUploadServer(t)
+	u, err := NewHTTPUploader(HTTPUploaderConfig{URL: srv.URL + "/{name}", Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 1, 14, 0, 0, 0, time.Local)
+	for _, name := range []string{"app-2024-01-01T10.log", "app-2024-01-01T11.log"} {
+		if err = os.WriteFile(filepath.Join(dir, name), []byte(name+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first archive of the chain interrupted before its rename
+	codec, _ := LookupCodec("tar.gz")
+	recovered := filepath.Join(dir, "app-2024-01-01T12-00-00.tar.gz")
+	err = archiveCompress(recovered, 0644, codec, 0, func(w ArchiveWriter) error {
+		source := filepath.Join(dir, "app-2024-01-01T10.log")
+		fi, _ := os.Stat(source)
+		file, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		mf, err := addMember(w, "app-2024-01-01T10.log", fi, file)
+		if err != nil {
+			return err
+		}
+		m := &Manifest{Version: manifestVersion, Created: now.Add(-2 * time.Hour), Logger: "app.log", Sequence: 1,
+			Files: []ManifestFile{mf}}
+		return addManifest(w, codec, recovered, m, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Rename(recovered, recovered+archiveTempSuffix); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewWriter(Config{
+		Filename:        filepath.Join(dir, "app.log"),
+		Rotation:        "1h",
+		Backup:          "1h",
+		Archive:         "10",
+		ArchiveChecksum: true,
+	}, WithClock(ClockFunc(func() time.Time { return now })), WithArchiveUploader(u), WithArchiveOnShutdown())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the recovered archive is finished like the one of the pass after it
+	if _, err = VerifyArchive(recovered); err != nil {
+		t.Errorf("expected the recovered archive to have its checksum: %v", err)
+	}
+	if _, err = os.Stat(recovered + checksumSuffix); err != nil {
+		t.Error(err)
+	}
+	uploaded := s.uploaded()
+	for _, name := range []string{"app-2024-01-01T12-00-00.tar.gz", "app-2024-01-01T14-00-00.tar.gz"} {
+		if _, ok := uploaded["/"+name]; !ok {
+			t.Errorf("expected %s to be uploaded, got %d files", name, len(uploaded))
+		}
+	}
+	m, err := VerifyArchive(filepath.Join(dir, "app-2024-01-01T14-00-00.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Sequence != 2 || m.PreviousArchive != filepath.Base(recovered) {
+		t.Errorf("expected the next archive to follow the recovered one, got %d after %s", m.Sequence, m.PreviousArchive)
+	}
+	if err = VerifyChain(dir, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+type failingCodec struct{ Codec }
+
+func (c failingCodec) Suffix() string {
+	return ".failing" + c.Codec.Suffix()
+}
+
+func (c failingCodec) NewWriter(w io.Writer, level int) (ArchiveWriter, error) {
+	aw, err := c.Codec.NewWriter(w, level)
+	return &failingArchiveWriter{ArchiveWriter: aw}, err
+}
+
+type failingArchiveWriter struct {
+	ArchiveWriter
+	added bool
+}
+
+func (w *failingArchiveWriter) Add(name string, info os.FileInfo, r io.Reader) error {
+	w.added = true
+	return w.ArchiveWriter.Add(name, info, r)
+}
+
+func (w *failingArchiveWriter) Close() error {
+	err := w.ArchiveWriter.Close()
+	if w.added {
+		return errors.New("close failed")
+	}
+	return err
+}
+
+func TestArchiveFailureKeepsBackups(t *testing.T) {
+	codec, _ := LookupCodec("tar.gz")
+	RegisterCodec("failing.tar.gz", failingCodec{codec})
+
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 1, 14, 0, 0, 0, time.Local)
+
+	backup := filepath.Join(dir, "app-2024-01-01T10.log")
+	if err := os.WriteFile(backup, []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewWriter(Config{
+		Filename:      filepath.Join(dir, "app.log"),
+		Rotation:      "1h",
+		Backup:        "1h",
+		Archive:       "10",
+		ArchiveFormat: "failing.tar.gz",
+	}, WithClock(ClockFunc(func() time.Time { return now })), WithArchiveOnShutdown())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err == nil {
+		t.Error("expected the final archive pass to fail")
+	}
+
+	if _, err = os.Stat(backup); err != nil {
+		t.Errorf("expected the backup to be kept: %v", err)
+	}
+	if archives, _ := filepath.Glob(filepath.Join(dir, "*.tar.gz*")); len(archives) != 0 {
+		t.Errorf("expected no archive to be left, got %v", archives)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestArchiveScheduler(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 1, 14, 0, 0, 0, time.Local)
+	backup := func(name string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("hello\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archives := func() int {
+		files, _ := filepath.Glob(filepath.Join(dir, "app-*.tar.gz"))
+		return len(files)
+	}
+
+	// backups left by a previous run are archived by the pass at startup
+	backup("app-2024-01-01T10.log")
+	w, err := NewWriter(Config{
+		Filename: filepath.Join(dir, "app.log"),
+		Rotation: "1h",
+		Backup:   "1h",
+		Archive:  "10",
+	}, WithClock(ClockFunc(func() time.Time { return now })), WithArchiveInterval(20*time.Millisecond, 5*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	waitFor(t, "the pass at startup", func() bool { return archives() == 1 })
+
+	// the ticker archives backups while nothing is written
+	if err = os.Remove(filepath.Join(dir, "app-2024-01-01T14-00-00.tar.gz")); err != nil {
+		t.Fatal(err)
+	}
+	backup("app-2024-01-01T11.log")
+	waitFor(t, "a scheduled pass", func() bool { return archives() == 1 })
+}
+
+func TestArchiveOnRotationOnly(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 1, 14, 0, 0, 0, time.Local)
+
+	w, err := NewWriter(Config{
+		Filename: filepath.Join(dir, "app.log"),
+		Rotation: "1kb",
+		Backup:   "1",
+		Archive:  "10",
+	}, WithClock(ClockFunc(func() time.Time { return now })), WithArchiveInterval(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	waitFor(t, "the pass at startup", func() bool { return w.Stats().ArchivePasses == 1 })
+	for i := 0; i < 100; i++ {
+		if _, err = w.Write([]byte("hello\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if passes := w.Stats().ArchivePasses; passes != 1 {
+		t.Fatalf("expected writes not to start archive passes, got %d passes", passes)
+	}
+
+	// the 1kb file is rotated by this write
+	if _, err = w.Write(bytes.Repeat([]byte("x"), 500)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the pass after the rotation", func() bool { return w.Stats().ArchivePasses == 2 })
+}
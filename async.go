@@ -0,0 +1,246 @@
+package loggeradapter
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultAsyncBufferSize    = 1024 * 1024 // 1MB
+	defaultAsyncBatchSize     = 64 * 1024   // 64KB
+	defaultAsyncFlushInterval = time.Second
+)
+
+// OverflowPolicy decides what an asynchronous Write does when the buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes Write wait for the flusher to make room.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest discards the write that doesn't fit.
+	OverflowDropNewest
+	// OverflowDropOldest discards the oldest buffered writes until it fits.
+	OverflowDropOldest
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropNewest:
+		return "drop-newest"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	default:
+		return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+	}
+}
+
+// AsyncConfig configures the asynchronous mode enabled by WithAsync.
+type AsyncConfig struct {
+	// BufferSize is the capacity of the buffer in bytes, 1MB by default.
+	// A single write can't be larger.
+	BufferSize int
+	// BatchSize is the number of buffered bytes that makes the flusher write
+	// them without waiting for FlushInterval, 64KB by default.
+	BatchSize int
+	// FlushInterval is the longest time writes stay buffered, 1s by default.
+	FlushInterval time.Duration
+	// Overflow is what Write does when the buffer is full, OverflowBlock by default.
+	Overflow OverflowPolicy
+}
+
+// asyncWriter buffers writes in a ring and writes them to the rotator from
+// a flusher goroutine. Writes are kept whole: each is written, rotated
+// around or dropped as a unit.
+type asyncWriter struct {
+	rotator      *rotator
+	batchSize    int
+	interval     time.Duration
+	overflow     OverflowPolicy
+	errorHandler ErrorHandler
+
+	mu      sync.Mutex
+	space   *sync.Cond // signalled when the flusher takes the buffer
+	buf     []byte
+	head    int   // offset of the oldest buffered byte
+	size    int   // number of buffered bytes
+	lens    []int // lengths of the buffered writes, oldest first from first
+	first   int
+	closed  bool
+	wake    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+
+	// flushMu keeps the buffer taken and written by one goroutine at a
+	// time, so writes reach the file in order
+	flushMu     sync.Mutex
+	scratch     []byte
+	scratchLens []int
+
+	droppedBytes  int64
+	droppedWrites int64
+}
+
+func newAsyncWriter(r *rotator, cfg AsyncConfig, errorHandler ErrorHandler) (*asyncWriter, error) {
+	if cfg.Overflow < OverflowBlock || cfg.Overflow > OverflowDropOldest {
+		return nil, &ConfigError{Field: "Overflow", Value: cfg.Overflow.String(), Reason: "unknown overflow policy"}
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = defaultAsyncBufferSize
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultAsyncBatchSize
+	}
+	if cfg.BatchSize > cfg.BufferSize {
+		cfg.BatchSize = cfg.BufferSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultAsyncFlushInterval
+	}
+
+	w := &asyncWriter{
+		rotator:      r,
+		batchSize:    cfg.BatchSize,
+		interval:     cfg.FlushInterval,
+		overflow:     cfg.Overflow,
+		errorHandler: errorHandler,
+		buf:          make([]byte, cfg.BufferSize),
+		wake:         make(chan struct{}, 1),
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+	}
+	w.space = sync.NewCond(&w.mu)
+	go w.run()
+	return w, nil
+}
+
+// write buffers p, it only fails once the writer is closed or when p can
+// never fit in the buffer. Dropped writes are reported as written.
+func (w *asyncWriter) write(p []byte) (int, error) {
+	if len(p) > len(w.buf) {
+		return 0, fmt.Errorf("write length %d exceeds the async buffer size %d", len(p), len(w.buf))
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for !w.closed && len(w.buf)-w.size < len(p) {
+		switch w.overflow {
+		case OverflowDropNewest:
+			w.drop(len(p))
+			return len(p), nil
+		case OverflowDropOldest:
+			for len(w.buf)-w.size < len(p) {
+				l := w.lens[w.first]
+				w.first++
+				w.head = (w.head + l) % len(w.buf)
+				w.size -= l
+				w.drop(l)
+			}
+		default:
+			w.notify()
+			w.space.Wait()
+		}
+	}
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	// the write may wrap around the end of the ring
+	tail := (w.head + w.size) % len(w.buf)
+	if n := copy(w.buf[tail:], p); n < len(p) {
+		copy(w.buf, p[n:])
+	}
+	w.size += len(p)
+	w.lens = append(w.lens, len(p))
+
+	if w.size >= w.batchSize {
+		w.notify()
+	}
+	return len(p), nil
+}
+
+func (w *asyncWriter) drop(n int) {
+	atomic.AddInt64(&w.droppedBytes, int64(n))
+	atomic.AddInt64(&w.droppedWrites, 1)
+}
+
+// notify wakes the flusher without waiting for it.
+func (w *asyncWriter) notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run is the flusher goroutine, it writes the buffer every interval and
+// whenever a batch is ready.
+func (w *asyncWriter) run() {
+	defer close(w.stopped)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+
+		if err := w.flush(); err != nil {
+			w.errorHandler("write", err)
+		}
+	}
+}
+
+// flush writes everything buffered so far to the rotator.
+func (w *asyncWriter) flush() error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	if w.size == 0 {
+		w.mu.Unlock()
+		return nil
+	}
+	if end := w.head + w.size; end <= len(w.buf) {
+		w.scratch = append(w.scratch[:0], w.buf[w.head:end]...)
+	} else {
+		w.scratch = append(w.scratch[:0], w.buf[w.head:]...)
+		w.scratch = append(w.scratch, w.buf[:end-len(w.buf)]...)
+	}
+	w.scratchLens = append(w.scratchLens[:0], w.lens[w.first:]...)
+	w.head, w.size = 0, 0
+	w.lens, w.first = w.lens[:0], 0
+	w.space.Broadcast()
+	w.mu.Unlock()
+
+	return w.rotator.writeRecords(w.scratch, w.scratchLens)
+}
+
+// close stops taking writes, flushes the buffer and stops the flusher.
+func (w *asyncWriter) close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.space.Broadcast()
+	w.mu.Unlock()
+
+	close(w.done)
+	<-w.stopped
+	return w.flush()
+}
+
+func (w *asyncWriter) snapshot(stats *Stats) {
+	stats.DroppedBytes = atomic.LoadInt64(&w.droppedBytes)
+	stats.DroppedWrites = atomic.LoadInt64(&w.droppedWrites)
+}
@@ -0,0 +1,215 @@
+package loggeradapter
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newAsyncTestWriter(t *testing.T, cfg Config, async AsyncConfig) (*Writer, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	cfg.Filename = filepath.Join(dir, "app.log")
+	w, err := NewWriter(cfg, WithAsync(async))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = w.Close() })
+	return w, cfg.Filename
+}
+
+func readFile(t *testing.T, filename string) string {
+	t.Helper()
+
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestAsyncWriter(t *testing.T) {
+	w, filename := newAsyncTestWriter(t, Config{}, AsyncConfig{BufferSize: 64, FlushInterval: time.Hour})
+
+	var want strings.Builder
+	for i := 0; i < 100; i++ {
+		line := strings.Repeat(string(rune('a'+i%26)), 9) + "\n"
+		want.WriteString(line)
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filename); got != want.String() {
+		t.Fatalf("expected the writes in order after Sync, got %q", got)
+	}
+
+	if _, err := w.Write([]byte("last\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filename); got != want.String()+"last\n" {
+		t.Errorf("expected Close to flush the buffer, got %q", got)
+	}
+	if _, err := w.Write([]byte("closed\n")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected writes after Close to fail, got %v", err)
+	}
+	if _, err := w.Write(make([]byte, 65)); err == nil {
+		t.Error("expected a write larger than the buffer to fail")
+	}
+}
+
+func TestAsyncWriterFlushInterval(t *testing.T) {
+	w, filename := newAsyncTestWriter(t, Config{}, AsyncConfig{FlushInterval: 10 * time.Millisecond})
+
+	if _, err := w.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filename); got != "" {
+		t.Errorf("expected the write to be buffered, got %q", got)
+	}
+	waitFor(t, "the flush", func() bool { return readFile(t, filename) == "hello\n" })
+}
+
+func TestAsyncWriterBatchSize(t *testing.T) {
+	w, filename := newAsyncTestWriter(t, Config{}, AsyncConfig{BatchSize: 10, FlushInterval: time.Hour})
+
+	if _, err := w.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("world\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the batch", func() bool { return readFile(t, filename) == "hello\nworld\n" })
+}
+
+func TestAsyncWriterOverflow(t *testing.T) {
+	for _, tc := range []struct {
+		overflow OverflowPolicy
+		want     string
+	}{
+		{OverflowDropNewest, "000000000\n111111111\n222222222\n"},
+		{OverflowDropOldest, "111111111\n222222222\n333333333\n"},
+	} {
+		t.Run(tc.overflow.String(), func(t *testing.T) {
+			w, filename := newAsyncTestWriter(t, Config{}, AsyncConfig{BufferSize: 30, FlushInterval: time.Hour, Overflow: tc.overflow})
+
+			// the flusher can't take the buffer while the test holds it
+			w.async.flushMu.Lock()
+			for i := 0; i < 4; i++ {
+				if n, err := w.Write([]byte(strings.Repeat(string(rune('0'+i)), 9) + "\n")); n != 10 || err != nil {
+					t.Fatalf("expected dropped writes to succeed, got %d, %v", n, err)
+				}
+			}
+			w.async.flushMu.Unlock()
+
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := readFile(t, filename); got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+			if stats := w.Stats(); stats.DroppedBytes != 10 || stats.DroppedWrites != 1 {
+				t.Errorf("unexpected stats %+v", stats)
+			}
+		})
+	}
+}
+
+func TestAsyncWriterBlock(t *testing.T) {
+	w, filename := newAsyncTestWriter(t, Config{}, AsyncConfig{BufferSize: 20, FlushInterval: time.Hour})
+
+	w.async.flushMu.Lock()
+	for _, line := range []string{"000000000\n", "111111111\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := w.Write([]byte("222222222\n"))
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		t.Fatalf("expected the write to wait for room, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	w.async.flushMu.Unlock()
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filename); got != "000000000\n111111111\n222222222\n" {
+		t.Errorf("unexpected content %q", got)
+	}
+	if stats := w.Stats(); stats.DroppedBytes != 0 {
+		t.Errorf("expected nothing to be dropped, got %+v", stats)
+	}
+}
+
+func TestAsyncWriterRotation(t *testing.T) {
+	w, filename := newAsyncTestWriter(t, Config{Rotation: "25b"}, AsyncConfig{FlushInterval: time.Hour})
+
+	for i := 0; i < 10; i++ {
+		if _, err := w.Write([]byte(strings.Repeat(string(rune('0'+i)), 9) + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// writes flushed together are still rotated around one by one
+	files, _ := filepath.Glob(filepath.Join(filepath.Dir(filename), "app*.log"))
+	var all []byte
+	for _, f := range files {
+		b := []byte(readFile(t, f))
+		if len(b) == 0 || len(b) > 20 || len(b)%10 != 0 {
+			t.Errorf("unexpected content of %s: %q", f, b)
+		}
+		all = append(all, b...)
+	}
+	if len(files) != 5 || bytes.Count(all, []byte("\n")) != 10 {
+		t.Errorf("expected 10 writes in 5 files, got %d files %q", len(files), all)
+	}
+}
+
+func TestAsyncWriterConfigError(t *testing.T) {
+	_, err := NewWriter(Config{Filename: filepath.Join(t.TempDir(), "app.log")}, WithAsync(AsyncConfig{Overflow: 7}))
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || cfgErr.Field != "Overflow" {
+		t.Errorf("expected an Overflow config error, got %v", err)
+	}
+}
+
+func BenchmarkWriteAsync(b *testing.B) {
+	w, err := NewWriter(Config{Filename: filepath.Join(b.TempDir(), "app.log"), Rotation: "1gb"}, WithAsync(AsyncConfig{}))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer w.Close()
+
+	line := []byte("2024-01-01T10:00:00Z INFO request served method=GET path=/ status=200\n")
+	b.SetBytes(int64(len(line)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = w.Write(line); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
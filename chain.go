@@ -0,0 +1,473 @@
+package loggeradapter
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ChainError lists the problems found by VerifyChain.
+type ChainError struct {
+	Dir        string
+	Mismatches []Mismatch
+}
+
+func (e *ChainError) Error() string {
+	reasons := make([]string, 0, len(e.Mismatches))
+	for _, m := range e.Mismatches {
+		reasons = append(reasons, m.Name+": "+m.Reason)
+	}
+	return fmt.Sprintf("archive chain in %s is broken: %s", e.Dir, strings.Join(reasons, "; "))
+}
+
+// ChainReport is what VerifyChainReport went through, archive names are
+// relative to the directory and in sequence order for each logger.
+type ChainReport struct {
+	// Archives are the archives checked.
+	Archives []string
+	// Pruned are the archives removed by retention that the chain was
+	// followed through by their pruned records.
+	Pruned []string
+}
+
+type chainLink struct {
+	rel      string
+	sha256   string
+	manifest *Manifest
+	pruned   bool
+}
+
+// VerifyChain walks the archives in dir and checks each logger's chain in
+// sequence order: every archive must match its manifest, follow the archive
+// before it without gaps, carry its hash and not be older than it. With a
+// public key every manifest must also be signed by the matching private key.
+// The first archive of a chain isn't checked against its predecessor, which
+// may have been removed by the retention policy, archives the policy removed
+// after it are followed through the pruned sidecars they left, which must be
+// signed as well with a public key. Archives without a manifest are skipped,
+// a *ChainError lists every problem found. Encrypted archives are decrypted
+// with the keys given by WithArchiveEncryption.
+func VerifyChain(dir string, publicKey ed25519.PublicKey, opts ...Option) error {
+	_, err := VerifyChainReport(dir, publicKey, opts...)
+	return err
+}
+
+// VerifyChainReport verifies the chain like VerifyChain and also reports the
+// archives it went through, so archives crossed by their pruned records can
+// be told apart. The report is returned along with a *ChainError.
+func VerifyChainReport(dir string, publicKey ed25519.PublicKey, opts ...Option) (*ChainReport, error) {
+	o := newOptions(opts...)
+	cerr := &ChainError{Dir: dir}
+	chains := make(map[string][]chainLink)
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(path, prunedSuffix) {
+			rel, _ := filepath.Rel(dir, strings.TrimSuffix(path, prunedSuffix))
+			pruned, err := readPruned(path)
+			if err != nil {
+				cerr.Mismatches = append(cerr.Mismatches, Mismatch{rel, "invalid pruned record: " + err.Error()})
+				return nil
+			}
+			if pruned.Archive != filepath.Base(rel) || pruned.Sequence != pruned.Manifest.Sequence {
+				cerr.Mismatches = append(cerr.Mismatches, Mismatch{rel, "pruned record doesn't match its manifest"})
+				return nil
+			}
+			if publicKey != nil && (!verifyPrunedSignature(pruned, publicKey) ||
+				!verifyManifestSignature(pruned.Manifest, publicKey)) {
+				cerr.Mismatches = append(cerr.Mismatches, Mismatch{rel, "bad signature of the pruned record"})
+				return nil
+			}
+			chains[pruned.Manifest.Logger] = append(chains[pruned.Manifest.Logger],
+				chainLink{rel: rel, sha256: pruned.SHA256, manifest: pruned.Manifest, pruned: true})
+			return nil
+		}
+		if d.IsDir() || isArchiveSidecar(path) {
+			return nil
+		}
+		if _, ok := codecBySuffix(strings.TrimSuffix(path, encryptedSuffix)); !ok {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(dir, path)
+		codec, err := archiveCodec(path, o.keys)
+		if err != nil {
+			cerr.Mismatches = append(cerr.Mismatches, Mismatch{rel, err.Error()})
+			return nil
+		}
+
+		manifest, err := verifyArchive(path, codec)
+		var verr *VerifyError
+		switch {
+		case errors.As(err, &verr):
+			for _, m := range verr.Mismatches {
+				cerr.Mismatches = append(cerr.Mismatches, Mismatch{rel, m.Name + ": " + m.Reason})
+			}
+		case errors.Is(err, fs.ErrNotExist):
+			return nil
+		case err != nil:
+			cerr.Mismatches = append(cerr.Mismatches, Mismatch{rel, err.Error()})
+			return nil
+		}
+
+		if publicKey != nil && !verifyManifestSignature(manifest, publicKey) {
+			cerr.Mismatches = append(cerr.Mismatches, Mismatch{rel, "bad signature"})
+		}
+
+		sum, err := fileSHA256(path)
+		if err != nil {
+			return err
+		}
+		chains[manifest.Logger] = append(chains[manifest.Logger], chainLink{rel: rel, sha256: sum, manifest: manifest})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	loggers := make([]string, 0, len(chains))
+	for logger := range chains {
+		loggers = append(loggers, logger)
+	}
+	sort.Strings(loggers)
+
+	report := &ChainReport{}
+	for _, logger := range loggers {
+		cerr.Mismatches = append(cerr.Mismatches, verifyLinks(chains[logger])...)
+		for _, link := range chains[logger] {
+			if link.pruned {
+				report.Pruned = append(report.Pruned, link.rel)
+			} else {
+				report.Archives = append(report.Archives, link.rel)
+			}
+		}
+	}
+
+	if len(cerr.Mismatches) > 0 {
+		return report, cerr
+	}
+	return report, nil
+}
+
+func verifyLinks(links []chainLink) []Mismatch {
+	sort.SliceStable(links, func(i, j int) bool {
+		return links[i].manifest.Sequence < links[j].manifest.Sequence
+	})
+
+	var mismatches []Mismatch
+	for i := 1; i < len(links); i++ {
+		prev, cur := links[i-1], links[i]
+
+		switch seq := cur.manifest.Sequence; {
+		case seq == prev.manifest.Sequence:
+			mismatches = append(mismatches, Mismatch{cur.rel, fmt.Sprintf("sequence %d is also used by %s", seq, prev.rel)})
+		case seq != prev.manifest.Sequence+1:
+			mismatches = append(mismatches, Mismatch{cur.rel,
+				fmt.Sprintf("gap after %s: sequences %d to %d are missing", prev.rel, prev.manifest.Sequence+1, seq-1)})
+		case cur.manifest.PreviousSHA256 != prev.sha256:
+			mismatches = append(mismatches, Mismatch{cur.rel, "hash of the previous archive " + prev.rel + " doesn't match"})
+		}
+
+		if cur.manifest.Created.Before(prev.manifest.Created) {
+			mismatches = append(mismatches, Mismatch{cur.rel, "created before the previous archive " + prev.rel})
+		}
+	}
+
+	return mismatches
+}
+
+func isArchiveSidecar(path string) bool {
+	return strings.HasSuffix(path, archiveTempSuffix) ||
+		strings.HasSuffix(path, manifestSuffix) ||
+		strings.HasSuffix(path, encryptedManifestSuffix) ||
+		strings.HasSuffix(path, checksumSuffix) ||
+		strings.HasSuffix(path, prunedSuffix)
+}
+
+// signManifest signs the manifest encoded without its signature.
+func signManifest(m *Manifest, key ed25519.PrivateKey) error {
+	m.Signature = nil
+	content, err := m.encode()
+	if err != nil {
+		return err
+	}
+	m.Signature = ed25519.Sign(key, content)
+	return nil
+}
+
+func verifyManifestSignature(m *Manifest, key ed25519.PublicKey) bool {
+	if len(m.Signature) == 0 {
+		return false
+	}
+
+	unsigned := *m
+	unsigned.Signature = nil
+	content, err := unsigned.encode()
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(key, content, m.Signature)
+}
+
+// readArchiveManifest returns the manifest of an archive without verifying it.
+func readArchiveManifest(path string, codec Codec) (*Manifest, error) {
+	return readManifest(openLocal, path, codec)
+}
+
+func readManifest(open func(name string) (io.ReadCloser, error), name string, codec Codec) (*Manifest, error) {
+	if !codec.MultiFile() {
+		return readManifestSidecar(open, name, codec)
+	}
+
+	file, err := open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r, err := codec.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	for {
+		member, mr, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fs.ErrNotExist
+		}
+		if err != nil {
+			return nil, err
+		}
+		if member == ManifestName {
+			return decodeManifest(mr)
+		}
+	}
+}
+
+// chainHead is the last archive written by the archiver.
+type chainHead struct {
+	loaded   bool
+	name     string
+	sha256   string
+	sequence uint64
+}
+
+// linkManifest chains the manifest to the last archive and signs it.
+func (a *archiver) linkManifest(m *Manifest) error {
+	if !a.chain.loaded {
+		if err := a.loadChain(); err != nil {
+			return err
+		}
+	}
+
+	m.Logger = a.logger
+	m.Sequence = a.chain.sequence + 1
+	if a.chain.name != "" {
+		m.PreviousArchive = path.Base(a.chain.name)
+		m.PreviousSHA256 = a.chain.sha256
+	}
+
+	if a.signingKey == nil {
+		return nil
+	}
+	return signManifest(m, a.signingKey)
+}
+
+// loadChain finds the last archive of a previous run, archives of older
+// versions without a manifest start a new sequence.
+func (a *archiver) loadChain() error {
+	archives, err := a.indexedArchives()
+	if err != nil {
+		return err
+	}
+
+	a.chain = chainHead{loaded: true}
+	if len(archives) == 0 {
+		return nil
+	}
+
+	last := archives[0].rel
+	open := func(name string) (io.ReadCloser, error) { return a.store.Open(a.ctx, name) }
+
+	file, err := open(last)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, file)
+	_ = file.Close()
+	if err != nil {
+		return err
+	}
+	a.chain.name = last
+	a.chain.sha256 = hex.EncodeToString(h.Sum(nil))
+
+	if codec, err := archiveCodec(last, a.keys); err == nil {
+		if m, err := readManifest(open, last, codec); err == nil {
+			a.chain.sequence = m.Sequence
+		}
+	}
+	return nil
+}
+
+// advanceChain makes the archive just written the head of the chain, path is
+// its local copy.
+func (a *archiver) advanceChain(name, path string, m *Manifest) error {
+	sum, err := fileSHA256(path)
+	if err != nil {
+		a.chain.loaded = false
+		return err
+	}
+	a.chain = chainHead{loaded: true, name: name, sha256: sum}
+	// archives without a manifest start a new sequence like in loadChain
+	if m != nil {
+		a.chain.sequence = m.Sequence
+	}
+	return nil
+}
+
+// prunedSuffix is the sidecar an archive removed by retention leaves behind,
+// it keeps the archive's manifest and hash so VerifyChain can follow the
+// chain across archives thinned out of the middle, such as by GFS retention.
+const prunedSuffix = ".pruned.json"
+
+// prunedArchive is signed like manifests, so a record can't be made up for
+// an archive removed by someone else.
+type prunedArchive struct {
+	Archive   string    `json:"archive"`
+	SHA256    string    `json:"sha256"`
+	Sequence  uint64    `json:"sequence"`
+	Pruned    time.Time `json:"pruned"`
+	Manifest  *Manifest `json:"manifest"`
+	Signature []byte    `json:"signature,omitempty"`
+}
+
+// signPruned signs the record encoded without its signature.
+func signPruned(p *prunedArchive, key ed25519.PrivateKey) error {
+	p.Signature = nil
+	content, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	p.Signature = ed25519.Sign(key, content)
+	return nil
+}
+
+func verifyPrunedSignature(p *prunedArchive, key ed25519.PublicKey) bool {
+	if len(p.Signature) == 0 {
+		return false
+	}
+
+	unsigned := *p
+	unsigned.Signature = nil
+	content, err := json.Marshal(&unsigned)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(key, content, p.Signature)
+}
+
+// pruneArchive removes an archive for the retention policy, recording it in
+// a pruned sidecar first. Archives without a manifest don't take part in the
+// chain and are removed as is.
+func (a *archiver) pruneArchive(name string) error {
+	open := func(name string) (io.ReadCloser, error) { return a.store.Open(a.ctx, name) }
+
+	if codec, err := archiveCodec(name, a.keys); err == nil {
+		if m, err := readManifest(open, name, codec); err == nil {
+			file, err := open(name)
+			if err != nil {
+				return err
+			}
+			h := sha256.New()
+			_, err = io.Copy(h, file)
+			_ = file.Close()
+			if err != nil {
+				return err
+			}
+
+			record := &prunedArchive{
+				Archive:  path.Base(name),
+				SHA256:   hex.EncodeToString(h.Sum(nil)),
+				Sequence: m.Sequence,
+				Pruned:   a.clock.Now(),
+				Manifest: m,
+			}
+			if a.signingKey != nil {
+				if err = signPruned(record, a.signingKey); err != nil {
+					return err
+				}
+			}
+			content, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			if err = a.store.Put(a.ctx, name+prunedSuffix, bytes.NewReader(content)); err != nil {
+				return err
+			}
+		}
+	}
+	return a.deleteArchive(name)
+}
+
+// dropPruned removes the pruned sidecars older than the oldest archive, the
+// start of the chain isn't checked against them.
+func (a *archiver) dropPruned() error {
+	archives, err := a.indexedArchives()
+	if err != nil {
+		return err
+	}
+	infos, err := a.store.List(a.ctx)
+	if err != nil {
+		return fmt.Errorf("can't list archives: %w", err)
+	}
+
+	suffixes := a.archives.suffixList()
+	for _, info := range infos {
+		if !strings.HasSuffix(info.Name, prunedSuffix) {
+			continue
+		}
+		t, seq, ok := a.archives.parseSuffixed(strings.TrimSuffix(info.Name, prunedSuffix), suffixes)
+		if !ok {
+			continue
+		}
+		if len(archives) > 0 && !byFormatTime([]logInfo{archives[len(archives)-1], {timestamp: t, seq: seq}}).Less(0, 1) {
+			continue
+		}
+		if err = a.store.Delete(a.ctx, info.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readPruned returns the chain link recorded by a pruned sidecar.
+func readPruned(filename string) (*prunedArchive, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var pruned prunedArchive
+	if err = json.Unmarshal(content, &pruned); err != nil {
+		return nil, err
+	}
+	if pruned.Manifest == nil {
+		return nil, errors.New("no manifest")
+	}
+	return &pruned, nil
+}
@@ -0,0 +1,203 @@
+package loggeradapter
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyChain(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := writeArchivedBackups(t, Config{Compress: true}, map[string]string{
+		"app-2024-01-01T10.log": "one\n",
+		"app-2024-01-01T11.log": "two\n",
+		"app-2024-01-01T12.log": "three\n",
+	}, WithArchiveSigning(private))
+
+	names := []string{"app-2024-01-01T10.log.gz", "app-2024-01-01T11.log.gz", "app-2024-01-01T12.log.gz"}
+	for i, name := range names {
+		m, err := VerifyArchive(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Sequence != uint64(i+1) || m.Logger != "app.log" {
+			t.Errorf("%s: unexpected sequence %d of logger %s", name, m.Sequence, m.Logger)
+		}
+		if i > 0 && m.PreviousArchive != names[i-1] {
+			t.Errorf("%s: expected previous archive %s, got %s", name, names[i-1], m.PreviousArchive)
+		}
+	}
+
+	if err = VerifyChain(dir, public); err != nil {
+		t.Fatal(err)
+	}
+
+	other, _, _ := ed25519.GenerateKey(nil)
+	var cerr *ChainError
+	if err = VerifyChain(dir, other); !errors.As(err, &cerr) || len(cerr.Mismatches) != len(names) {
+		t.Errorf("expected every signature to be rejected, got %v", err)
+	}
+
+	if err = removeArchive(filepath.Join(dir, names[1])); err != nil {
+		t.Fatal(err)
+	}
+	if err = VerifyChain(dir, public); !errors.As(err, &cerr) || len(cerr.Mismatches) != 1 {
+		t.Fatalf("expected a gap, got %v", err)
+	}
+	if cerr.Mismatches[0].Name != names[2] {
+		t.Errorf("expected the gap before %s, got %v", names[2], cerr.Mismatches)
+	}
+}
+
+func TestVerifyChainReplacedArchive(t *testing.T) {
+	dir := writeArchivedBackups(t, Config{Compress: true}, map[string]string{
+		"app-2024-01-01T10.log": "one\n",
+		"app-2024-01-01T11.log": "two\n",
+	})
+
+	// a consistent archive and manifest replacing the first one
+	first := filepath.Join(dir, "app-2024-01-01T10.log.gz")
+	backup := filepath.Join(t.TempDir(), "app-2024-01-01T10.log")
+	if err := os.WriteFile(backup, []byte("forged\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := readArchiveManifest(first, gzipCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := os.Stat(backup)
+	err = archiveCompress(first, 0644, gzipCodec{}, 0, func(w ArchiveWriter) error {
+		f, _ := os.Open(backup)
+		defer f.Close()
+		mf, err := addMember(w, fi.Name(), fi, f)
+		if err != nil {
+			return err
+		}
+		m.Files = []ManifestFile{mf}
+		return addManifest(w, gzipCodec{}, first, m, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cerr *ChainError
+	if err = VerifyChain(dir, nil); !errors.As(err, &cerr) || len(cerr.Mismatches) != 1 {
+		t.Fatalf("expected a broken link, got %v", err)
+	}
+	if cerr.Mismatches[0].Name != "app-2024-01-01T11.log.gz" {
+		t.Errorf("unexpected mismatch %v", cerr.Mismatches)
+	}
+}
+
+func TestVerifyChainPruned(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for _, name := range []string{
+		"app-2023-12-30T10.log", "app-2023-12-30T11.log",
+		"app-2023-12-31T10.log", "app-2023-12-31T11.log",
+		"app-2024-01-01T10.log",
+	} {
+		if err = os.WriteFile(filepath.Join(dir, name), []byte(name+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// daily retention thins out one backup of each day
+	now := time.Date(2024, 1, 1, 14, 0, 0, 0, time.Local)
+	w, err := NewWriter(Config{
+		Filename: filepath.Join(dir, "app.log"),
+		Rotation: "1h",
+		Archive:  "7d:daily",
+		Compress: true,
+	}, WithClock(ClockFunc(func() time.Time { return now })), WithArchiveSigning(private), WithArchiveOnShutdown())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	archives, _ := filepath.Glob(filepath.Join(dir, "*.log.gz"))
+	pruned, _ := filepath.Glob(filepath.Join(dir, "*"+prunedSuffix))
+	if len(archives) != 3 || len(pruned) == 0 {
+		t.Fatalf("expected 3 archives and pruned records, got %v and %v", archives, pruned)
+	}
+	report, err := VerifyChainReport(dir, public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Archives) != 3 || len(report.Pruned) != len(pruned) {
+		t.Errorf("expected the pruned links to be reported, got %+v", report)
+	}
+
+	// a pruned record has to match the hash the next archive carries
+	record, err := readPruned(pruned[len(pruned)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	record.SHA256 = strings.Repeat("0", 64)
+	if err = signPruned(record, private); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := json.Marshal(record)
+	if err = os.WriteFile(pruned[len(pruned)-1], content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var cerr *ChainError
+	if err = VerifyChain(dir, public); !errors.As(err, &cerr) || len(cerr.Mismatches) != 1 {
+		t.Errorf("expected a broken link after the pruned archive, got %v", err)
+	}
+}
+
+func TestVerifyChainForgedPruned(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := writeArchivedBackups(t, Config{Compress: true}, map[string]string{
+		"app-2024-01-01T10.log": "one\n",
+		"app-2024-01-01T11.log": "two\n",
+		"app-2024-01-01T12.log": "three\n",
+	}, WithArchiveSigning(private))
+
+	// the archive's own signed manifest and hash don't make up for the
+	// signature of the record
+	archive := filepath.Join(dir, "app-2024-01-01T11.log.gz")
+	m, err := VerifyArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := fileSHA256(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := json.Marshal(prunedArchive{Archive: filepath.Base(archive), SHA256: sum, Sequence: m.Sequence, Manifest: m})
+	if err = removeArchive(archive); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(archive+prunedSuffix, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var cerr *ChainError
+	if err = VerifyChain(dir, public); !errors.As(err, &cerr) {
+		t.Fatalf("expected the forged record to be rejected, got %v", err)
+	}
+	if cerr.Mismatches[0].Name != filepath.Base(archive) || !strings.Contains(cerr.Mismatches[0].Reason, "signature") {
+		t.Errorf("unexpected mismatches %v", cerr.Mismatches)
+	}
+}
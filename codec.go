@@ -0,0 +1,429 @@
+package loggeradapter
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const defaultArchiveFormat = "tar.gz"
+
+// Codec writes and reads archives of one format.
+type Codec interface {
+	// Suffix is the file name suffix of the archives, such as .tar.gz.
+	Suffix() string
+	// MultiFile reports whether an archive can hold more than one file,
+	// backups are archived one by one otherwise.
+	MultiFile() bool
+	// NewWriter returns a writer adding files to an archive written to w,
+	// a level of 0 selects the default compression level.
+	NewWriter(w io.Writer, level int) (ArchiveWriter, error)
+	// NewReader returns a reader iterating over the files of an archive.
+	NewReader(r io.Reader) (ArchiveReader, error)
+}
+
+// ArchiveWriter adds files to an archive, Close flushes the archive without
+// closing the underlying writer.
+type ArchiveWriter interface {
+	Add(name string, info os.FileInfo, r io.Reader) error
+	Close() error
+}
+
+// ArchiveReader iterates over the files of an archive, Next returns io.EOF
+// after the last file.
+type ArchiveReader interface {
+	Next() (name string, r io.Reader, err error)
+	Close() error
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		"tar.gz": NewTarCodec(".tar.gz", newGzipWriter, newGzipReader),
+		"tar":    NewTarCodec(".tar", nil, nil),
+		"zip":    zipCodec{},
+		"gz":     gzipCodec{},
+	}
+)
+
+// RegisterCodec registers a codec under the name used by Config.ArchiveFormat,
+// it replaces a codec registered under the same name.
+func RegisterCodec(name string, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[name] = codec
+}
+
+// LookupCodec returns the codec registered under name.
+func LookupCodec(name string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, ok := codecs[name]
+	return codec, ok
+}
+
+// codecSuffixes returns the suffixes of all registered codecs and of their
+// encrypted archives, longest first.
+func codecSuffixes() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	suffixes := make([]string, 0, 2*len(codecs))
+	seen := make(map[string]bool, len(codecs))
+	for _, codec := range codecs {
+		if s := codec.Suffix(); !seen[s] {
+			seen[s] = true
+			suffixes = append(suffixes, s, s+encryptedSuffix)
+		}
+	}
+
+	sort.Slice(suffixes, func(i, j int) bool {
+		if len(suffixes[i]) != len(suffixes[j]) {
+			return len(suffixes[i]) > len(suffixes[j])
+		}
+		return suffixes[i] < suffixes[j]
+	})
+	return suffixes
+}
+
+// codecBySuffix returns the registered codec whose suffix ends name.
+func codecBySuffix(name string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	var (
+		found  Codec
+		length int
+	)
+	for _, codec := range codecs {
+		if s := codec.Suffix(); strings.HasSuffix(name, s) && len(s) > length {
+			found, length = codec, len(s)
+		}
+	}
+	return found, found != nil
+}
+
+// NewTarCodec returns a codec writing tar archives through the given
+// compressor, such as zstd or xz. Without compress and decompress the tar
+// stream is stored as is.
+func NewTarCodec(
+	suffix string,
+	compress func(w io.Writer, level int) (io.WriteCloser, error),
+	decompress func(r io.Reader) (io.ReadCloser, error),
+) Codec {
+	return tarCodec{suffix: suffix, compress: compress, decompress: decompress}
+}
+
+type tarCodec struct {
+	suffix     string
+	compress   func(w io.Writer, level int) (io.WriteCloser, error)
+	decompress func(r io.Reader) (io.ReadCloser, error)
+}
+
+func (c tarCodec) Suffix() string {
+	return c.suffix
+}
+
+func (c tarCodec) MultiFile() bool {
+	return true
+}
+
+func (c tarCodec) NewWriter(w io.Writer, level int) (ArchiveWriter, error) {
+	tw := &tarArchiveWriter{}
+
+	if c.compress != nil {
+		cw, err := c.compress(w, level)
+		if err != nil {
+			return nil, err
+		}
+		tw.compressor = cw
+		w = cw
+	}
+
+	tw.w = tar.NewWriter(w)
+	return tw, nil
+}
+
+func (c tarCodec) NewReader(r io.Reader) (ArchiveReader, error) {
+	tr := &tarArchiveReader{}
+
+	if c.decompress != nil {
+		dr, err := c.decompress(r)
+		if err != nil {
+			return nil, err
+		}
+		tr.decompressor = dr
+		r = dr
+	}
+
+	tr.r = tar.NewReader(r)
+	return tr, nil
+}
+
+type tarArchiveWriter struct {
+	w          *tar.Writer
+	compressor io.WriteCloser
+}
+
+func (w *tarArchiveWriter) Add(name string, info os.FileInfo, r io.Reader) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    int64(info.Mode().Perm()),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+
+	if err := w.w.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err := io.Copy(w.w, r)
+	return err
+}
+
+func (w *tarArchiveWriter) Close() error {
+	err := w.w.Close()
+	if w.compressor != nil {
+		if cerr := w.compressor.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+type tarArchiveReader struct {
+	r            *tar.Reader
+	decompressor io.ReadCloser
+}
+
+func (r *tarArchiveReader) Next() (string, io.Reader, error) {
+	for {
+		header, err := r.r.Next()
+		if err != nil {
+			return "", nil, err
+		}
+		if header.Typeflag == tar.TypeReg {
+			return header.Name, r.r, nil
+		}
+	}
+}
+
+func (r *tarArchiveReader) Close() error {
+	if r.decompressor != nil {
+		return r.decompressor.Close()
+	}
+	return nil
+}
+
+func newGzipWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+func newGzipReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// zipCodec writes deflated zip archives.
+type zipCodec struct{}
+
+func (zipCodec) Suffix() string {
+	return ".zip"
+}
+
+func (zipCodec) MultiFile() bool {
+	return true
+}
+
+func (zipCodec) NewWriter(w io.Writer, level int) (ArchiveWriter, error) {
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		return nil, fmt.Errorf("invalid zip compression level: %d", level)
+	}
+
+	zw := zip.NewWriter(w)
+	zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, level)
+	})
+	return zipArchiveWriter{zw}, nil
+}
+
+// NewReader spools the archive to a temporary file since zip archives are
+// read from their end.
+func (zipCodec) NewReader(r io.Reader) (ArchiveReader, error) {
+	tmp, err := os.CreateTemp("", "loggeradapter-*.zip")
+	if err != nil {
+		return nil, err
+	}
+
+	zr := &zipArchiveReader{tmp: tmp}
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		_ = zr.Close()
+		return nil, err
+	}
+
+	if zr.r, err = zip.NewReader(tmp, size); err != nil {
+		_ = zr.Close()
+		return nil, err
+	}
+	return zr, nil
+}
+
+type zipArchiveWriter struct {
+	w *zip.Writer
+}
+
+func (w zipArchiveWriter) Add(name string, info os.FileInfo, r io.Reader) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+
+	fw, err := w.w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(fw, r)
+	return err
+}
+
+func (w zipArchiveWriter) Close() error {
+	return w.w.Close()
+}
+
+type zipArchiveReader struct {
+	tmp  *os.File
+	r    *zip.Reader
+	next int
+	file io.ReadCloser
+}
+
+func (r *zipArchiveReader) Next() (string, io.Reader, error) {
+	if r.file != nil {
+		_ = r.file.Close()
+		r.file = nil
+	}
+
+	for r.next < len(r.r.File) {
+		f := r.r.File[r.next]
+		r.next++
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return "", nil, err
+		}
+		r.file = rc
+		return f.Name, rc, nil
+	}
+
+	return "", nil, io.EOF
+}
+
+func (r *zipArchiveReader) Close() error {
+	if r.file != nil {
+		_ = r.file.Close()
+	}
+	err := r.tmp.Close()
+	_ = os.Remove(r.tmp.Name())
+	return err
+}
+
+// gzipCodec compresses a single file to a plain .gz file.
+type gzipCodec struct{}
+
+func (gzipCodec) Suffix() string {
+	return ".gz"
+}
+
+func (gzipCodec) MultiFile() bool {
+	return false
+}
+
+func (gzipCodec) NewWriter(w io.Writer, level int) (ArchiveWriter, error) {
+	gw, err := newGzipWriter(w, level)
+	if err != nil {
+		return nil, err
+	}
+	return &gzipArchiveWriter{w: gw.(*gzip.Writer)}, nil
+}
+
+func (gzipCodec) NewReader(r io.Reader) (ArchiveReader, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	gr.Multistream(false)
+	return &gzipArchiveReader{r: gr}, nil
+}
+
+type gzipArchiveWriter struct {
+	w     *gzip.Writer
+	added bool
+}
+
+func (w *gzipArchiveWriter) Add(name string, info os.FileInfo, r io.Reader) error {
+	if w.added {
+		return errors.New("gzip archive holds a single file")
+	}
+	w.added = true
+
+	// gzip headers only hold Latin-1 names, the manifest has the others
+	if isLatin1(name) {
+		w.w.Name = name
+	}
+	w.w.ModTime = info.ModTime()
+
+	_, err := io.Copy(w.w, r)
+	return err
+}
+
+func isLatin1(s string) bool {
+	for _, r := range s {
+		if r == 0 || r > 0xff {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *gzipArchiveWriter) Close() error {
+	return w.w.Close()
+}
+
+type gzipArchiveReader struct {
+	r    *gzip.Reader
+	read bool
+}
+
+// Next returns the name of the header, which is empty when the file name
+// isn't Latin-1.
+func (r *gzipArchiveReader) Next() (string, io.Reader, error) {
+	if r.read {
+		return "", nil, io.EOF
+	}
+	r.read = true
+	return r.r.Name, r.r, nil
+}
+
+func (r *gzipArchiveReader) Close() error {
+	return r.r.Close()
+}
@@ -0,0 +1,177 @@
+package loggeradapter
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"app-2024-01-01.log":   "first\n",
+		"2024/app-2024-01.log": "second\n",
+	}
+	names := []string{"app-2024-01-01.log", "2024/app-2024-01.log"}
+
+	for _, format := range []string{"tar.gz", "tar", "zip", "gz"} {
+		codec, ok := LookupCodec(format)
+		if !ok {
+			t.Fatalf("%s: codec not registered", format)
+		}
+
+		var buf bytes.Buffer
+		w, err := codec.NewWriter(&buf, 9)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		written := names
+		if !codec.MultiFile() {
+			written = names[:1]
+		}
+		for _, name := range written {
+			filename := filepath.Join(dir, filepath.Base(name))
+			if err = os.WriteFile(filename, []byte(files[name]), 0644); err != nil {
+				t.Fatal(err)
+			}
+			fi, _ := os.Stat(filename)
+			if err = w.Add(name, fi, bytes.NewBufferString(files[name])); err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+		}
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := codec.NewReader(&buf)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		var read []string
+		for {
+			name, rr, err := r.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			content, _ := io.ReadAll(rr)
+			if string(content) != files[name] {
+				t.Errorf("%s: unexpected content of %s: %q", format, name, content)
+			}
+			read = append(read, name)
+		}
+		_ = r.Close()
+
+		if len(read) != len(written) {
+			t.Errorf("%s: read %v, want %v", format, read, written)
+		}
+	}
+}
+
+func TestRegisteredCodecArchives(t *testing.T) {
+	RegisterCodec("tar.zz", NewTarCodec(".tar.zz",
+		func(w io.Writer, level int) (io.WriteCloser, error) {
+			if level == 0 {
+				level = zlib.DefaultCompression
+			}
+			return zlib.NewWriterLevel(w, level)
+		},
+		func(r io.Reader) (io.ReadCloser, error) { return zlib.NewReader(r) },
+	))
+
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+
+	// an archive of another format is still subject to retention
+	old := filepath.Join(dir, "app-2023-01-01T10-00-00.zip")
+	if err := os.WriteFile(old, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewWriter(Config{
+		Filename:      filepath.Join(dir, "app.log"),
+		Backup:        "1",
+		Archive:       "1",
+		ArchiveFormat: "tar.zz",
+	}, WithClock(ClockFunc(func() time.Time { return now })), WithArchiveOnShutdown())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = w.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, "app-2024-01-01T10-00-00.tar.zz")); err != nil {
+		t.Errorf("expected archive: %v", err)
+	}
+	if _, err = os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("expected the old zip archive to be pruned, got %v", err)
+	}
+}
+
+func TestArchiveFormatConfigError(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "app.log")
+
+	for _, cfg := range []Config{
+		{Filename: filename, ArchiveFormat: "rar"},
+		{Filename: filename, ArchiveFormat: "zip", ArchiveLevel: 42},
+	} {
+		var cfgErr *ConfigError
+		if _, err := NewWriter(cfg); !errors.As(err, &cfgErr) {
+			t.Errorf("%+v: expected *ConfigError, got %v", cfg, err)
+		}
+	}
+}
+
+func TestGzipNonLatin1Name(t *testing.T) {
+	for _, cfg := range []Config{
+		{ArchiveFormat: "gz", Backup: "1h", Archive: "10"},
+		{Compress: true},
+	} {
+		dir := t.TempDir()
+		backup := filepath.Join(dir, "应用-2024-01-01T10.log")
+		if err := os.WriteFile(backup, []byte("hello\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		now := time.Date(2024, 1, 1, 14, 0, 0, 0, time.Local)
+		cfg.Filename = filepath.Join(dir, "应用.log")
+		cfg.Rotation = "1h"
+		w, err := NewWriter(cfg, WithClock(ClockFunc(func() time.Time { return now })), WithArchiveOnShutdown(),
+			WithErrorHandler(func(op string, err error) { t.Errorf("%s: %v", op, err) }))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("%+v: %v", cfg, err)
+		}
+
+		archives, _ := filepath.Glob(filepath.Join(dir, "*.gz"))
+		if len(archives) != 1 || fileExists(backup) {
+			t.Fatalf("%+v: expected the backup to be archived, got %v", cfg, archives)
+		}
+		m, err := VerifyArchive(archives[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Files) != 1 || m.Files[0].Name != "应用-2024-01-01T10.log" {
+			t.Errorf("%+v: unexpected manifest %+v", cfg, m.Files)
+		}
+	}
+}
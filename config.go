@@ -0,0 +1,123 @@
+package loggeradapter
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ConfigError reports a Config field that can't be used to build a Writer.
+type ConfigError struct {
+	Field  string
+	Value  string
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid %s %q: %s", e.Field, e.Value, e.Reason)
+}
+
+func (cfg Config) validate() error {
+	if err := validateFilename(cfg.Filename); err != nil {
+		return err
+	}
+	if err := validateRotation(cfg.Rotation); err != nil {
+		return err
+	}
+	if err := validateRetention("Backup", cfg.Backup); err != nil {
+		return err
+	}
+	if IsGFS(cfg.Archive) {
+		if _, err := parseGFS(cfg.Archive); err != nil {
+			return &ConfigError{Field: "Archive", Value: cfg.Archive, Reason: err.Error()}
+		}
+	} else if err := validateRetention("Archive", cfg.Archive); err != nil {
+		return err
+	}
+	if _, err := parseSizeLimit("MaxTotalSize", cfg.MaxTotalSize); err != nil {
+		return err
+	}
+	if _, err := parseSizeLimit("MinFreeSpace", cfg.MinFreeSpace); err != nil {
+		return err
+	}
+	if _, err := newFilePattern(cfg.BackupPattern, "log.log", defaultTimeFormat, nil); err != nil {
+		return &ConfigError{Field: "BackupPattern", Value: cfg.BackupPattern, Reason: err.Error()}
+	}
+	if _, err := newFilePattern(archivePatternOrDefault(cfg.ArchivePattern), "log.log", defaultArchiveTimeFormat, nil); err != nil {
+		return &ConfigError{Field: "ArchivePattern", Value: cfg.ArchivePattern, Reason: err.Error()}
+	}
+	if cfg.Compress && cfg.ArchivePattern != "" {
+		return &ConfigError{Field: "ArchivePattern", Value: cfg.ArchivePattern,
+			Reason: "compressed backups are named after the backup, it can't be used with Compress"}
+	}
+	return validateArchiveFormat(cfg.ArchiveFormat, cfg.ArchiveLevel, cfg.Compress)
+}
+
+func validateArchiveFormat(format string, level int, compress bool) error {
+	codec, ok := LookupCodec(archiveFormatOrDefault(format, compress))
+	if !ok {
+		return &ConfigError{Field: "ArchiveFormat", Value: format, Reason: "unknown archive format"}
+	}
+
+	w, err := codec.NewWriter(io.Discard, level)
+	if err != nil {
+		return &ConfigError{Field: "ArchiveLevel", Value: strconv.Itoa(level), Reason: err.Error()}
+	}
+	_ = w.Close()
+	return nil
+}
+
+func validateFilename(filename string) error {
+	if filename == "" {
+		return nil
+	}
+
+	if strings.HasSuffix(filename, "/") || strings.HasSuffix(filename, string(os.PathSeparator)) {
+		return &ConfigError{Field: "Filename", Value: filename, Reason: "must name a file, not a directory"}
+	}
+
+	if fi, err := os.Stat(filename); err == nil && fi.IsDir() {
+		return &ConfigError{Field: "Filename", Value: filename, Reason: "is an existing directory"}
+	}
+
+	return nil
+}
+
+func validateRotation(rotation string) error {
+	interval, size, err := ParseRotation(rotation)
+	if err != nil {
+		return &ConfigError{Field: "Rotation", Value: rotation, Reason: err.Error()}
+	}
+
+	for _, term := range []string{interval, size} {
+		if term == "" || IsCron(term) {
+			continue
+		}
+		if v, _, _ := ParseExpression(term); v <= 0 {
+			return &ConfigError{Field: "Rotation", Value: rotation, Reason: "must be greater than zero"}
+		}
+	}
+
+	return nil
+}
+
+func validateRetention(field, expression string) error {
+	if expression == "" {
+		return nil
+	}
+
+	v, unit, err := ParseExpression(expression)
+	if err != nil {
+		return &ConfigError{Field: field, Value: expression, Reason: err.Error()}
+	}
+	if unit != "" && !IsDuration(unit) {
+		return &ConfigError{Field: field, Value: expression, Reason: "must be a time interval or a number"}
+	}
+	if v <= 0 {
+		return &ConfigError{Field: field, Value: expression, Reason: "must be greater than zero"}
+	}
+
+	return nil
+}
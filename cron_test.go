@@ -0,0 +1,52 @@
+package loggeradapter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	at := func(y int, m time.Month, d, h, min int) time.Time {
+		return time.Date(y, m, d, h, min, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		expression string
+		from, next time.Time
+	}{
+		{"0 0 * * *", at(2024, 1, 1, 14, 37), at(2024, 1, 2, 0, 0)},
+		{"@midnight", at(2024, 1, 1, 0, 0), at(2024, 1, 2, 0, 0)},
+		{"@hourly", at(2024, 1, 1, 14, 37), at(2024, 1, 1, 15, 0)},
+		{"*/15 * * * *", at(2024, 1, 1, 14, 37), at(2024, 1, 1, 14, 45)},
+		// 2024-01-05 is a Friday, the next weekday is Monday 2024-01-08
+		{"0 2 * * 1-5", at(2024, 1, 5, 3, 0), at(2024, 1, 8, 2, 0)},
+		{"0 */6 * * mon-fri", at(2024, 1, 1, 7, 0), at(2024, 1, 1, 12, 0)},
+		{"30 4 1,15 * *", at(2024, 1, 2, 0, 0), at(2024, 1, 15, 4, 30)},
+		{"0 0 29 2 *", at(2023, 3, 1, 0, 0), at(2024, 2, 29, 0, 0)},
+		{"0 0 * * 7", at(2024, 1, 1, 0, 0), at(2024, 1, 7, 0, 0)},
+		// either day of month or day of week matches when both are restricted
+		{"0 0 13 * 5", at(2024, 1, 1, 0, 0), at(2024, 1, 5, 0, 0)},
+	}
+
+	for _, c := range cases {
+		s, err := ParseCron(c.expression)
+		if err != nil {
+			t.Errorf("%s: %v", c.expression, err)
+			continue
+		}
+		if next := s.Next(c.from); !next.Equal(c.next) {
+			t.Errorf("%s from %v: got %v, want %v", c.expression, c.from, next, c.next)
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expression := range []string{
+		"0 0 * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *",
+		"*/0 * * * *", "5-1 * * * *", "@sometimes", "a b c d e",
+	} {
+		if _, err := ParseCron(expression); err == nil {
+			t.Errorf("%s: expected error", expression)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd
+
+package loggeradapter
+
+import "syscall"
+
+// statDiskFree returns the bytes available to unprivileged users on the file
+// system holding dir.
+func statDiskFree(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// statSameFileSystem reports whether a and b are on the same file system,
+// paths that can't be stat'ed are assumed to be.
+func statSameFileSystem(a, b string) bool {
+	var sa, sb syscall.Stat_t
+	if syscall.Stat(a, &sa) != nil || syscall.Stat(b, &sb) != nil {
+		return true
+	}
+	return sa.Dev == sb.Dev
+}
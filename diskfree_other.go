@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !freebsd
+
+package loggeradapter
+
+import "errors"
+
+// statDiskFree isn't supported on this platform, MinFreeSpace reports an
+// error on every archive pass.
+func statDiskFree(_ string) (int64, error) {
+	return 0, errors.New("free disk space can't be checked on this platform")
+}
+
+// statSameFileSystem assumes a single file system, MinFreeSpace can't be
+// used on this platform anyway.
+func statSameFileSystem(_, _ string) bool {
+	return true
+}
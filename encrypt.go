@@ -0,0 +1,431 @@
+package loggeradapter
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// encryptedSuffix is appended to the suffix of encrypted archives,
+	// e.g. app-2024-01-01T10-00-00.tar.gz.enc.
+	encryptedSuffix = ".enc"
+
+	encryptedMagic     = "LAENC"
+	encryptedVersion   = 1
+	encryptedChunkSize = 64 * 1024
+	encryptedPrefixLen = 7
+)
+
+// KeyProvider provides the AES-256 keys archives are encrypted with, keys
+// are identified by an ID recorded in the header of every encrypted archive
+// so they can be rotated.
+type KeyProvider interface {
+	// CurrentKey returns the key new archives are encrypted with.
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with the given ID to decrypt an archive.
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeys is a KeyProvider holding its keys in memory, Current is the ID
+// of the key new archives are encrypted with.
+type StaticKeys struct {
+	Current string
+	Keys    map[string][]byte
+}
+
+func (k StaticKeys) CurrentKey() (string, []byte, error) {
+	key, err := k.Key(k.Current)
+	return k.Current, key, err
+}
+
+func (k StaticKeys) Key(id string) ([]byte, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %q", id)
+	}
+	return key, nil
+}
+
+// OpenArchive opens an archive for reading, encrypted archives are decrypted
+// with the keys of the provider, which may be nil for plain archives.
+func OpenArchive(path string, keys KeyProvider) (ArchiveReader, error) {
+	codec, err := archiveCodec(path, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := codec.NewReader(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &fileArchiveReader{ArchiveReader: r, file: file}, nil
+}
+
+type fileArchiveReader struct {
+	ArchiveReader
+	file *os.File
+}
+
+func (r *fileArchiveReader) Close() error {
+	err := r.ArchiveReader.Close()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// archiveCodec returns the codec of the archive at path, encrypted archives
+// need the keys to be read.
+func archiveCodec(path string, keys KeyProvider) (Codec, error) {
+	name := strings.TrimSuffix(path, encryptedSuffix)
+
+	codec, ok := codecBySuffix(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown archive format of %s", path)
+	}
+	if name == path {
+		return codec, nil
+	}
+	if keys == nil {
+		return nil, fmt.Errorf("archive %s is encrypted and no KeyProvider was given", path)
+	}
+	return encryptedCodec{Codec: codec, keys: keys}, nil
+}
+
+// encryptAll encrypts content with the current key of the provider, in the
+// format of encrypted archives.
+func encryptAll(content []byte, keys KeyProvider) ([]byte, error) {
+	id, key, err := keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	ew, err := newEncryptWriter(&buf, id, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = ew.Write(content); err != nil {
+		return nil, err
+	}
+	if err = ew.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decryptAll reads and decrypts what encryptAll wrote.
+func decryptAll(r io.Reader, keys KeyProvider) ([]byte, error) {
+	dr, err := newDecryptReader(r, keys)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(dr)
+}
+
+// encryptedCodec encrypts the output of a codec with AES-256-GCM. The stream
+// is cut into chunks sealed one by one, so memory stays bounded, the nonce of
+// each chunk is a random prefix, its index and whether it's the last one, so
+// chunks can't be reordered, dropped or truncated unnoticed.
+//
+// The header is the magic, the version, the key ID length and ID, the chunk
+// size and the nonce prefix, it's authenticated with every chunk. Each chunk
+// is a flag marking the last one, the length of the sealed chunk and the
+// sealed chunk.
+type encryptedCodec struct {
+	Codec
+	keys KeyProvider
+}
+
+func (c encryptedCodec) Suffix() string {
+	return c.Codec.Suffix() + encryptedSuffix
+}
+
+func (c encryptedCodec) NewWriter(w io.Writer, level int) (ArchiveWriter, error) {
+	id, key, err := c.keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+
+	ew, err := newEncryptWriter(w, id, key)
+	if err != nil {
+		return nil, err
+	}
+
+	aw, err := c.Codec.NewWriter(ew, level)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedArchiveWriter{ArchiveWriter: aw, encrypter: ew}, nil
+}
+
+func (c encryptedCodec) NewReader(r io.Reader) (ArchiveReader, error) {
+	dr, err := newDecryptReader(r, c.keys)
+	if err != nil {
+		return nil, err
+	}
+	return c.Codec.NewReader(dr)
+}
+
+type encryptedArchiveWriter struct {
+	ArchiveWriter
+	encrypter *encryptWriter
+}
+
+func (w *encryptedArchiveWriter) Close() error {
+	err := w.ArchiveWriter.Close()
+	if closeErr := w.encrypter.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, 0, encryptedPrefixLen+5)
+	nonce = append(nonce, prefix...)
+	nonce = appendUint32(nonce, index)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+type encryptWriter struct {
+	w      io.Writer
+	gcm    cipher.AEAD
+	header []byte
+	prefix []byte
+	buf    []byte
+	index  uint32
+	closed bool
+}
+
+func newEncryptWriter(w io.Writer, id string, key []byte) (*encryptWriter, error) {
+	if len(id) > 255 {
+		return nil, fmt.Errorf("encryption key ID %q is longer than 255 bytes", id)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, encryptedPrefixLen)
+	if _, err = rand.Read(prefix); err != nil {
+		return nil, err
+	}
+
+	header := append([]byte(encryptedMagic), encryptedVersion, byte(len(id)))
+	header = append(header, id...)
+	header = appendUint32(header, encryptedChunkSize)
+	header = append(header, prefix...)
+
+	if _, err = w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		w:      w,
+		gcm:    gcm,
+		header: header,
+		prefix: prefix,
+		buf:    make([]byte, 0, encryptedChunkSize),
+	}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, os.ErrClosed
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// a full chunk is only sealed once more data follows, the last
+		// chunk is sealed by Close
+		if len(e.buf) == encryptedChunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(e.buf[len(e.buf):encryptedChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	if e.index == ^uint32(0) {
+		return errors.New("encrypted archive is too large")
+	}
+
+	sealed := e.gcm.Seal(nil, chunkNonce(e.prefix, e.index, last), e.buf, e.header)
+
+	chunk := make([]byte, 0, 5)
+	if last {
+		chunk = append(chunk, 1)
+	} else {
+		chunk = append(chunk, 0)
+	}
+	chunk = appendUint32(chunk, uint32(len(sealed)))
+
+	if _, err := e.w.Write(chunk); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+
+	e.index++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+// Close seals the last chunk without closing the underlying writer.
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.seal(true)
+}
+
+type decryptReader struct {
+	r      *bufio.Reader
+	gcm    cipher.AEAD
+	header []byte
+	prefix []byte
+	max    int
+	buf    []byte
+	index  uint32
+	done   bool
+}
+
+func newDecryptReader(r io.Reader, keys KeyProvider) (*decryptReader, error) {
+	br := bufio.NewReader(r)
+
+	fixed := make([]byte, len(encryptedMagic)+2)
+	if _, err := io.ReadFull(br, fixed); err != nil {
+		return nil, fmt.Errorf("can't read encryption header: %s", err)
+	}
+	if string(fixed[:len(encryptedMagic)]) != encryptedMagic {
+		return nil, errors.New("not an encrypted archive")
+	}
+	if fixed[len(encryptedMagic)] != encryptedVersion {
+		return nil, fmt.Errorf("unsupported encryption version %d", fixed[len(encryptedMagic)])
+	}
+
+	rest := make([]byte, int(fixed[len(fixed)-1])+4+encryptedPrefixLen)
+	if _, err := io.ReadFull(br, rest); err != nil {
+		return nil, fmt.Errorf("can't read encryption header: %s", err)
+	}
+
+	idLen := int(fixed[len(fixed)-1])
+	key, err := keys.Key(string(rest[:idLen]))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	chunkSize := binary.BigEndian.Uint32(rest[idLen : idLen+4])
+	if chunkSize == 0 || chunkSize > 16*1024*1024 {
+		return nil, fmt.Errorf("invalid encryption chunk size %d", chunkSize)
+	}
+
+	return &decryptReader{
+		r:      br,
+		gcm:    gcm,
+		header: append(fixed, rest...),
+		prefix: rest[idLen+4:],
+		max:    int(chunkSize) + gcm.Overhead(),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *decryptReader) open() error {
+	chunk := make([]byte, 5)
+	if _, err := io.ReadFull(d.r, chunk); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("encrypted archive is truncated")
+		}
+		return err
+	}
+
+	last := chunk[0] == 1
+	size := int(binary.BigEndian.Uint32(chunk[1:]))
+	if size > d.max || chunk[0] > 1 {
+		return errors.New("invalid encrypted chunk")
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return errors.New("encrypted archive is truncated")
+	}
+
+	plain, err := d.gcm.Open(sealed[:0], chunkNonce(d.prefix, d.index, last), sealed, d.header)
+	if err != nil {
+		return fmt.Errorf("can't decrypt chunk %d: %s", d.index, err)
+	}
+
+	if last {
+		if _, err = d.r.ReadByte(); err != io.EOF {
+			return errors.New("unexpected data after the last encrypted chunk")
+		}
+		d.done = true
+	}
+
+	d.index++
+	d.buf = plain
+	return nil
+}
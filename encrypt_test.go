@@ -0,0 +1,170 @@
+package loggeradapter
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testKeys(t *testing.T, ids ...string) StaticKeys {
+	t.Helper()
+
+	keys := StaticKeys{Current: ids[0], Keys: make(map[string][]byte)}
+	for _, id := range ids {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			t.Fatal(err)
+		}
+		keys.Keys[id] = key
+	}
+	return keys
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	keys := testKeys(t, "2024-01", "2024-02")
+
+	plain := make([]byte, 3*encryptedChunkSize+123)
+	if _, err := rand.Read(plain); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{0, encryptedChunkSize, len(plain)} {
+		var buf bytes.Buffer
+		id, key, _ := keys.CurrentKey()
+		w, err := newEncryptWriter(&buf, id, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write(plain[:size]); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		sealed := buf.Bytes()
+
+		// the key is looked up by the ID in the header after a key rotation
+		rotated := keys
+		rotated.Current = "2024-02"
+		r, err := newDecryptReader(bytes.NewReader(sealed), rotated)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plain[:size]) {
+			t.Errorf("%d bytes: decrypted content differs", size)
+		}
+
+		// the last chunk holds the remainder, its flag, length and GCM tag
+		lastChunk := size%encryptedChunkSize + 5 + 16
+		if size > 0 && size%encryptedChunkSize == 0 {
+			lastChunk += encryptedChunkSize
+		}
+
+		tampered := append([]byte(nil), sealed...)
+		tampered[len(tampered)-1] ^= 1
+		for name, data := range map[string][]byte{
+			"tampered":  tampered,
+			"truncated": sealed[:len(sealed)-lastChunk],
+		} {
+			r, err = newDecryptReader(bytes.NewReader(data), keys)
+			if err == nil {
+				_, err = io.ReadAll(r)
+			}
+			if err == nil {
+				t.Errorf("%d bytes: expected %s content to fail decryption", size, name)
+			}
+		}
+	}
+}
+
+func TestEncryptedArchives(t *testing.T) {
+	keys := testKeys(t, "k1")
+
+	dir := writeArchivedBackups(t, Config{}, map[string]string{
+		"app-2024-01-01T10.log": "secret\n",
+	}, WithArchiveEncryption(keys))
+
+	archive := filepath.Join(dir, "app-2024-01-01T14-00-00.tar.gz.enc")
+	content, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(content, []byte("app-2024-01-01T10.log")) {
+		t.Error("expected the archive to be encrypted")
+	}
+
+	if _, err = VerifyArchive(archive); err == nil {
+		t.Error("expected verification without keys to fail")
+	}
+	if _, err = VerifyArchive(archive, WithArchiveEncryption(keys)); err != nil {
+		t.Fatal(err)
+	}
+	if err = VerifyChain(dir, nil, WithArchiveEncryption(keys)); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := OpenArchive(archive, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	name, mr, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(mr); name != "app-2024-01-01T10.log" || string(b) != "secret\n" {
+		t.Errorf("unexpected member %s: %q", name, b)
+	}
+	if name, _, err = r.Next(); name != ManifestName {
+		t.Errorf("expected the manifest, got %s: %v", name, err)
+	}
+	if _, _, err = r.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestEncryptedManifestSidecar(t *testing.T) {
+	keys := testKeys(t, "k1")
+
+	for _, cfg := range []Config{{ArchiveFormat: "gz"}, {Compress: true}} {
+		dir := writeArchivedBackups(t, cfg, map[string]string{
+			"app-2024-01-01T10.log": "secret\n",
+		}, WithArchiveEncryption(keys))
+
+		archives, _ := filepath.Glob(filepath.Join(dir, "*.gz.enc"))
+		if len(archives) != 1 {
+			t.Fatalf("%+v: expected an encrypted archive, got %v", cfg, archives)
+		}
+		archive := archives[0]
+		if _, err := os.Stat(archive + manifestSuffix); !os.IsNotExist(err) {
+			t.Errorf("%+v: expected no plaintext manifest, got %v", cfg, err)
+		}
+		content, err := os.ReadFile(archive + encryptedManifestSuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(content, []byte("app-2024-01-01T10.log")) {
+			t.Errorf("%+v: expected the manifest to be encrypted", cfg)
+		}
+
+		m, err := VerifyArchive(archive, WithArchiveEncryption(keys))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Files) != 1 || m.Files[0].Name != "app-2024-01-01T10.log" {
+			t.Errorf("%+v: unexpected manifest %+v", cfg, m.Files)
+		}
+		if err = VerifyChain(dir, nil, WithArchiveEncryption(keys)); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package loggeradapter
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// fileIndex keeps the backups and archives of a logger between passes, so
+// passes following rotations don't walk the directory or list the store.
+// It's loaded by the first pass that needs it and updated as files are
+// rotated, archived and pruned; scheduled passes reload it to pick up files
+// changed by someone else.
+type fileIndex struct {
+	mu                            sync.Mutex
+	backups, archives             []logInfo // newest first
+	backupsLoaded, archivesLoaded bool
+	backupScans, archiveListings  int
+
+	// rotated holds the backups made since the last pass, the rotator only
+	// takes rotatedMu so rotations never wait for a scan under mu
+	rotatedMu sync.Mutex
+	rotated   []logInfo
+}
+
+// invalidate makes the next pass reload the index.
+func (x *fileIndex) invalidate() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.backups, x.archives = nil, nil
+	x.backupsLoaded, x.archivesLoaded = false, false
+}
+
+// indexedBackups returns the backups, newest first.
+func (a *archiver) indexedBackups() ([]logInfo, error) {
+	x := &a.index
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if !x.backupsLoaded {
+		backups, err := a.backups.scan()
+		if err != nil {
+			return nil, err
+		}
+		x.backups, x.backupsLoaded = backups, true
+		x.backupScans++
+	}
+
+	// passes remove backups under runMu, so the ones still there are current
+	x.rotatedMu.Lock()
+	rotated := x.rotated
+	x.rotated = nil
+	x.rotatedMu.Unlock()
+	for _, f := range rotated {
+		if fileExists(f.path) {
+			x.insert(&x.backups, f)
+		}
+	}
+	return append([]logInfo(nil), x.backups...), nil
+}
+
+// indexedArchives returns the archives of the store, newest first.
+func (a *archiver) indexedArchives() ([]logInfo, error) {
+	x := &a.index
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if !x.archivesLoaded {
+		archives, err := a.listArchives()
+		if err != nil {
+			return nil, err
+		}
+		x.archives, x.archivesLoaded = archives, true
+		x.archiveListings++
+	}
+	return append([]logInfo(nil), x.archives...), nil
+}
+
+// rotated queues the backup the rotator just made for the next pass and
+// nudges the mill.
+func (a *archiver) rotated(filename string) {
+	if rel, err := filepath.Rel(a.backups.root, filename); err == nil {
+		rel = filepath.ToSlash(rel)
+		t, seq, ok := a.backups.parseSuffixed(rel, a.backups.suffixList())
+		fi, statErr := os.Stat(filename)
+		if ok && statErr == nil {
+			x := &a.index
+			x.rotatedMu.Lock()
+			x.rotated = append(x.rotated, logInfo{timestamp: t, seq: seq, path: filename, rel: rel, FileInfo: fi})
+			x.rotatedMu.Unlock()
+		}
+	}
+	a.archive()
+}
+
+// forgetBackup drops a removed backup.
+func (a *archiver) forgetBackup(filename string) {
+	a.index.remove(&a.index.backups, func(f logInfo) bool { return f.path == filename })
+}
+
+// addArchive adds an archive put into the store.
+func (a *archiver) addArchive(name string, size int64, modTime time.Time) {
+	t, seq, ok := a.archives.parseSuffixed(name, a.archives.suffixList())
+	if !ok {
+		return
+	}
+	a.index.add(&a.index.archives, &a.index.archivesLoaded, logInfo{
+		timestamp: t,
+		seq:       seq,
+		path:      a.archivePath(name),
+		rel:       name,
+		FileInfo:  memFileInfo{name: path.Base(name), size: size, mode: a.fileMode, modTime: modTime},
+	})
+}
+
+// forgetArchive drops a deleted archive.
+func (a *archiver) forgetArchive(name string) {
+	a.index.remove(&a.index.archives, func(f logInfo) bool { return f.rel == name })
+}
+
+// add inserts f into files, files that aren't loaded yet pick it up when
+// they are.
+func (x *fileIndex) add(files *[]logInfo, loaded *bool, f logInfo) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if *loaded {
+		x.insert(files, f)
+	}
+}
+
+// insert adds f to files or replaces the entry of the same name, with mu held.
+func (x *fileIndex) insert(files *[]logInfo, f logInfo) {
+	for i := range *files {
+		if (*files)[i].rel == f.rel {
+			(*files)[i] = f
+			return
+		}
+	}
+	*files = append(*files, f)
+	sort.Sort(byFormatTime(*files))
+}
+
+func (x *fileIndex) remove(files *[]logInfo, match func(f logInfo) bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	kept := (*files)[:0]
+	for _, f := range *files {
+		if !match(f) {
+			kept = append(kept, f)
+		}
+	}
+	*files = kept
+}
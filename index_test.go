@@ -0,0 +1,205 @@
+package loggeradapter
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func (x *fileIndex) scans() (int, int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.backupScans, x.archiveListings
+}
+
+func TestFileIndex(t *testing.T) {
+	dir := t.TempDir()
+	var mu sync.Mutex
+	now := time.Date(2024, 1, 1, 14, 0, 0, 0, time.Local)
+	clock := ClockFunc(func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	})
+
+	w, err := NewWriter(Config{
+		Filename: filepath.Join(dir, "app.log"),
+		Rotation: "1h",
+		Backup:   "1",
+		Archive:  "2",
+	}, WithClock(clock), WithArchiveInterval(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	a := w.archiver
+
+	waitFor(t, "the pass at startup", func() bool { return w.Stats().ArchivePasses == 1 })
+	for i := 0; i < 5; i++ {
+		if _, err = w.Write([]byte("hello\n")); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		now = now.Add(time.Hour)
+		mu.Unlock()
+		if err = w.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+		passes := int64(i + 2)
+		waitFor(t, "the pass after the rotation", func() bool { return w.Stats().ArchivePasses == passes })
+	}
+
+	// passes after rotations work off the index
+	if backups, archives := a.index.scans(); backups != 1 || archives != 1 {
+		t.Errorf("expected the directory to be scanned once, got %d scans and %d listings", backups, archives)
+	}
+
+	// the index matches the directory
+	backups, _ := a.indexedBackups()
+	archives, _ := a.indexedArchives()
+	files, _ := filepath.Glob(filepath.Join(dir, "app-*.log"))
+	gzipFiles, _ := filepath.Glob(filepath.Join(dir, "app-*.tar.gz"))
+	if len(backups) != len(files) || len(archives) != len(gzipFiles) || len(archives) != 2 {
+		t.Errorf("index of %d backups and %d archives, directory of %v and %v", len(backups), len(archives), files, gzipFiles)
+	}
+
+	// a rescan picks up files changed by someone else
+	if err = os.Remove(gzipFiles[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.runArchive(); err != nil {
+		t.Fatal(err)
+	}
+	if archives, _ = a.indexedArchives(); len(archives) != 1 {
+		t.Errorf("expected the removed archive to be dropped, got %d archives", len(archives))
+	}
+}
+
+func BenchmarkWrite(b *testing.B) {
+	dir := b.TempDir()
+	w, err := NewWriter(Config{
+		Filename: filepath.Join(dir, "app.log"),
+		Rotation: "1gb",
+		Backup:   "1",
+		Archive:  "2",
+	}, WithArchiveInterval(0, 0))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer w.Close()
+
+	line := []byte("2024-01-01T10:00:00Z INFO request served method=GET path=/ status=200\n")
+	b.SetBytes(int64(len(line)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = w.Write(line); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	// only the pass at startup looked at the directory
+	if backups, _ := w.archiver.index.scans(); backups > 1 {
+		b.Fatalf("writes scanned the directory %d times", backups)
+	}
+}
+
+func TestFileIndexStaleBackups(t *testing.T) {
+	dir := t.TempDir()
+	var mu sync.Mutex
+	now := time.Date(2024, 1, 1, 14, 0, 0, 0, time.Local)
+	clock := ClockFunc(func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	})
+	backup := func(name string) string {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, []byte("hello\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+
+	w, err := NewWriter(Config{
+		Filename: filepath.Join(dir, "app.log"),
+		Rotation: "1h",
+		Backup:   "1h",
+		Archive:  "10",
+	}, WithClock(clock), WithArchiveInterval(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	a := w.archiver
+	waitFor(t, "the pass at startup", func() bool { return w.Stats().ArchivePasses == 1 })
+
+	// a backup too recent to archive is indexed, then removed by someone else
+	recent := backup("app-2024-01-01T13.log")
+	if err = a.runArchive(); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(recent); err != nil {
+		t.Fatal(err)
+	}
+
+	// a rotation is queued for a backup a pass already archived
+	archived := backup("app-2024-01-01T12.log")
+	a.rotated(archived)
+	if err = os.Remove(archived); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	now = now.Add(2 * time.Hour)
+	mu.Unlock()
+	if err = a.runPass(false); err != nil {
+		t.Fatalf("expected stale backups to be skipped, got %v", err)
+	}
+	if backups, _ := a.indexedBackups(); len(backups) != 0 {
+		t.Errorf("expected stale backups to be dropped from the index, got %v", backups)
+	}
+}
+
+func TestFileIndexConcurrentRotations(t *testing.T) {
+	w, err := NewWriter(Config{
+		Filename: filepath.Join(t.TempDir(), "app.log"),
+		Rotation: "1kb",
+		Backup:   "1",
+		Archive:  "1000",
+	}, WithArchiveInterval(time.Millisecond, 0), WithArchiveRetry(1, 0, 0),
+		WithErrorHandler(func(op string, err error) { t.Errorf("%s: %v", op, err) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if _, err := w.Write([]byte("hello\n")); err != nil {
+					t.Error(err)
+					return
+				}
+				if j%10 == 0 {
+					if err := w.Rotate(); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if failures := w.Stats().ArchiveFailures; failures != 0 {
+		t.Errorf("expected no failed archive passes, got %d", failures)
+	}
+}
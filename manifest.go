@@ -0,0 +1,403 @@
+package loggeradapter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// ManifestName is the name of the manifest inside multi-file archives.
+	ManifestName = "MANIFEST.json"
+
+	// manifestSuffix names the manifest of single-file archives, which is
+	// written next to the archive, e.g. app-2024-01-01T10.log.gz.manifest.json.
+	manifestSuffix = ".manifest.json"
+	// encryptedManifestSuffix names the manifest of encrypted single-file
+	// archives, encrypted with the same key,
+	// e.g. app-2024-01-01T10.log.gz.enc.manifest.json.enc.
+	encryptedManifestSuffix = manifestSuffix + encryptedSuffix
+	// checksumSuffix names the sidecar with the SHA-256 of the archive file,
+	// it's in the format of sha256sum.
+	checksumSuffix = ".sha256"
+
+	manifestVersion = 1
+)
+
+// sidecarSuffixes name the files written next to an archive.
+var sidecarSuffixes = []string{manifestSuffix, encryptedManifestSuffix, checksumSuffix}
+
+// Manifest lists the files of an archive. The archives of a logger form a
+// chain: each manifest holds the sequence number of the archive and the
+// SHA-256 of the archive before it, and the signature of the manifest when
+// archives are signed.
+type Manifest struct {
+	Version int            `json:"version"`
+	Created time.Time      `json:"created"`
+	Files   []ManifestFile `json:"files"`
+
+	Logger          string `json:"logger"`
+	Sequence        uint64 `json:"sequence"`
+	PreviousArchive string `json:"previous_archive,omitempty"`
+	PreviousSHA256  string `json:"previous_sha256,omitempty"`
+	Signature       []byte `json:"signature,omitempty"`
+}
+
+// ManifestFile describes an archived file as it was before archiving.
+type ManifestFile struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Lines   int64     `json:"lines"`
+	SHA256  string    `json:"sha256"`
+}
+
+// Mismatch is a difference between an archive and its manifest.
+type Mismatch struct {
+	Name   string
+	Reason string
+}
+
+// VerifyError lists the mismatches found by VerifyArchive.
+type VerifyError struct {
+	Archive    string
+	Mismatches []Mismatch
+}
+
+func (e *VerifyError) Error() string {
+	reasons := make([]string, 0, len(e.Mismatches))
+	for _, m := range e.Mismatches {
+		reasons = append(reasons, m.Name+": "+m.Reason)
+	}
+	return fmt.Sprintf("archive %s doesn't match its manifest: %s", e.Archive, strings.Join(reasons, "; "))
+}
+
+// VerifyArchive re-reads the archive at path and checks every file against
+// its manifest, and the archive itself against its .sha256 sidecar when there
+// is one. The manifest is returned when the archive is intact, a *VerifyError
+// lists the mismatches otherwise. Encrypted archives are decrypted with the
+// keys given by WithArchiveEncryption.
+func VerifyArchive(path string, opts ...Option) (*Manifest, error) {
+	codec, err := archiveCodec(path, newOptions(opts...).keys)
+	if err != nil {
+		return nil, err
+	}
+	return verifyArchive(path, codec)
+}
+
+func verifyArchive(path string, codec Codec) (*Manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r, err := codec.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("can't read archive %s: %s", path, err)
+	}
+	defer r.Close()
+
+	var (
+		manifest *Manifest
+		digests  = make(map[string]ManifestFile)
+		names    []string
+	)
+	for {
+		name, mr, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't read archive %s: %s", path, err)
+		}
+
+		if name == ManifestName && codec.MultiFile() {
+			if manifest, err = decodeManifest(mr); err != nil {
+				return nil, fmt.Errorf("can't read manifest of %s: %s", path, err)
+			}
+			continue
+		}
+
+		if name == "" && !codec.MultiFile() {
+			if manifest, err = readManifestSidecar(openLocal, path, codec); err != nil {
+				return nil, fmt.Errorf("can't read manifest of %s: %w", path, err)
+			}
+			name = singleMemberName(manifest)
+		}
+
+		d := newDigestReader(mr)
+		if _, err = io.Copy(io.Discard, d); err != nil {
+			return nil, fmt.Errorf("can't read %s in archive %s: %s", name, path, err)
+		}
+		digests[name] = d.file(name, time.Time{})
+		names = append(names, name)
+	}
+
+	if manifest == nil {
+		if manifest, err = readManifestSidecar(openLocal, path, codec); err != nil {
+			return nil, fmt.Errorf("can't read manifest of %s: %w", path, err)
+		}
+	}
+
+	verr := &VerifyError{Archive: path}
+	listed := make(map[string]bool, len(manifest.Files))
+	for _, f := range manifest.Files {
+		listed[f.Name] = true
+
+		d, ok := digests[f.Name]
+		switch {
+		case !ok:
+			verr.Mismatches = append(verr.Mismatches, Mismatch{f.Name, "missing from the archive"})
+		case d.Size != f.Size:
+			verr.Mismatches = append(verr.Mismatches, Mismatch{f.Name, fmt.Sprintf("size %d, want %d", d.Size, f.Size)})
+		case d.SHA256 != f.SHA256:
+			verr.Mismatches = append(verr.Mismatches, Mismatch{f.Name, "sha256 " + d.SHA256 + ", want " + f.SHA256})
+		case d.Lines != f.Lines:
+			verr.Mismatches = append(verr.Mismatches, Mismatch{f.Name, fmt.Sprintf("%d lines, want %d", d.Lines, f.Lines)})
+		}
+	}
+	for _, name := range names {
+		if !listed[name] {
+			verr.Mismatches = append(verr.Mismatches, Mismatch{name, "not listed in the manifest"})
+		}
+	}
+
+	if m, err := verifyChecksum(path); err != nil {
+		return nil, err
+	} else if m != nil {
+		verr.Mismatches = append(verr.Mismatches, *m)
+	}
+
+	if len(verr.Mismatches) > 0 {
+		return manifest, verr
+	}
+	return manifest, nil
+}
+
+// verifyChecksum compares the archive with its .sha256 sidecar, it's fine
+// for the sidecar not to exist.
+func verifyChecksum(path string) (*Mismatch, error) {
+	content, err := os.ReadFile(path + checksumSuffix)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return &Mismatch{filepath.Base(path) + checksumSuffix, "empty checksum file"}, nil
+	}
+
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return nil, err
+	}
+	if sum != fields[0] {
+		return &Mismatch{filepath.Base(path), "sha256 " + sum + ", want " + fields[0]}, nil
+	}
+	return nil, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeChecksum writes the .sha256 sidecar of the archive.
+func writeChecksum(path string, mode os.FileMode) error {
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path+checksumSuffix, []byte(sum+"  "+filepath.Base(path)+"\n"), mode)
+}
+
+func decodeManifest(r io.Reader) (*Manifest, error) {
+	var manifest Manifest
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+func openLocal(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+// readManifestSidecar reads the manifest written next to a single-file
+// archive, decrypting it along with encrypted archives. Plaintext manifests of
+// encrypted archives written by older versions are read as well.
+func readManifestSidecar(open func(name string) (io.ReadCloser, error), name string, codec Codec) (*Manifest, error) {
+	if ec, ok := codec.(encryptedCodec); ok {
+		file, err := open(name + encryptedManifestSuffix)
+		if err == nil {
+			defer file.Close()
+			content, err := decryptAll(file, ec.keys)
+			if err != nil {
+				return nil, err
+			}
+			return decodeManifest(bytes.NewReader(content))
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	file, err := open(name + manifestSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return decodeManifest(file)
+}
+
+// singleMemberName returns the file of the manifest of a single-file archive,
+// for archives that don't hold its name.
+func singleMemberName(m *Manifest) string {
+	if len(m.Files) != 1 {
+		return ""
+	}
+	return m.Files[0].Name
+}
+
+func (m *Manifest) encode() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// addManifest adds the manifest to a multi-file archive, single-file archives
+// get it written next to them.
+func addManifest(w ArchiveWriter, codec Codec, path string, m *Manifest, mode os.FileMode) error {
+	content, err := m.encode()
+	if err != nil {
+		return err
+	}
+
+	if !codec.MultiFile() {
+		suffix := manifestSuffix
+		if ec, ok := codec.(encryptedCodec); ok {
+			if content, err = encryptAll(content, ec.keys); err != nil {
+				return err
+			}
+			suffix = encryptedManifestSuffix
+		}
+		return writeFileAtomic(path+suffix, content, mode)
+	}
+
+	info := memFileInfo{name: ManifestName, size: int64(len(content)), mode: mode, modTime: m.Created}
+	return w.Add(ManifestName, info, bytes.NewReader(content))
+}
+
+// removeArchive removes an archive along with its sidecars.
+func removeArchive(path string) error {
+	err := os.Remove(path)
+	removeSidecars(path)
+	return err
+}
+
+func removeSidecars(path string) {
+	for _, suffix := range sidecarSuffixes {
+		_ = os.Remove(path + suffix)
+	}
+}
+
+// writeFileAtomic writes a file under a temporary name, syncs it and renames
+// it into place.
+func writeFileAtomic(path string, content []byte, mode os.FileMode) error {
+	tmp := path + archiveTempSuffix
+
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(content); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
+
+// digestReader computes the size, line count and SHA-256 of what's read
+// through it.
+type digestReader struct {
+	r     io.Reader
+	hash  hash.Hash
+	size  int64
+	lines int64
+	last  byte
+}
+
+func newDigestReader(r io.Reader) *digestReader {
+	return &digestReader{r: r, hash: sha256.New()}
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if n > 0 {
+		d.hash.Write(p[:n])
+		d.size += int64(n)
+		d.lines += int64(bytes.Count(p[:n], []byte{'\n'}))
+		d.last = p[n-1]
+	}
+	return n, err
+}
+
+func (d *digestReader) file(name string, modTime time.Time) ManifestFile {
+	lines := d.lines
+	// a last line without a newline counts too
+	if d.size > 0 && d.last != '\n' {
+		lines++
+	}
+
+	return ManifestFile{
+		Name:    name,
+		Size:    d.size,
+		ModTime: modTime,
+		Lines:   lines,
+		SHA256:  hex.EncodeToString(d.hash.Sum(nil)),
+	}
+}
+
+// memFileInfo describes a file built in memory, such as the manifest.
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi memFileInfo) IsDir() bool        { return false }
+func (fi memFileInfo) Sys() interface{}   { return nil }
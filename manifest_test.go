@@ -0,0 +1,112 @@
+package loggeradapter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeArchivedBackups(t *testing.T, cfg Config, backups map[string]string, opts ...Option) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range backups {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Date(2024, 1, 1, 14, 0, 0, 0, time.Local)
+	cfg.Filename = filepath.Join(dir, "app.log")
+	cfg.Rotation = "1h"
+	cfg.Backup = "1h"
+	cfg.Archive = "10"
+
+	opts = append(opts, WithClock(ClockFunc(func() time.Time { return now })), WithArchiveOnShutdown())
+	w, err := NewWriter(cfg, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestVerifyArchive(t *testing.T) {
+	backups := map[string]string{
+		"app-2024-01-01T10.log": "one\ntwo\n",
+		"app-2024-01-01T11.log": "three\nfour",
+	}
+	dir := writeArchivedBackups(t, Config{ArchiveChecksum: true}, backups)
+
+	archive := filepath.Join(dir, "app-2024-01-01T14-00-00.tar.gz")
+	manifest, err := VerifyArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manifest.Files) != len(backups) {
+		t.Fatalf("expected %d files in the manifest, got %+v", len(backups), manifest.Files)
+	}
+	for _, f := range manifest.Files {
+		sum := sha256.Sum256([]byte(backups[f.Name]))
+		if f.SHA256 != hex.EncodeToString(sum[:]) || f.Size != int64(len(backups[f.Name])) || f.Lines != 2 {
+			t.Errorf("unexpected manifest entry %+v", f)
+		}
+	}
+
+	// replace a member, keeping the manifest
+	codec, _ := LookupCodec("tar.gz")
+	tampered := filepath.Join(t.TempDir(), "tampered.tar.gz")
+	err = archiveCompress(tampered, 0644, codec, 0, func(w ArchiveWriter) error {
+		for _, f := range manifest.Files {
+			content := backups[f.Name]
+			if f.Name == "app-2024-01-01T11.log" {
+				content = "three\nfive"
+			}
+			info := memFileInfo{name: f.Name, size: int64(len(content)), mode: 0644}
+			if err := w.Add(f.Name, info, strings.NewReader(content)); err != nil {
+				return err
+			}
+		}
+		return addManifest(w, codec, tampered, manifest, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Rename(tampered, archive); err != nil {
+		t.Fatal(err)
+	}
+
+	var verr *VerifyError
+	if _, err = VerifyArchive(archive); !errors.As(err, &verr) {
+		t.Fatalf("expected *VerifyError, got %v", err)
+	}
+	if len(verr.Mismatches) != 2 {
+		t.Errorf("expected the member and the checksum to mismatch, got %v", verr.Mismatches)
+	}
+}
+
+func TestVerifyArchiveSingleFile(t *testing.T) {
+	dir := writeArchivedBackups(t, Config{Compress: true}, map[string]string{
+		"app-2024-01-01T10.log": "one\n",
+	})
+
+	archive := filepath.Join(dir, "app-2024-01-01T10.log.gz")
+	if _, err := VerifyArchive(archive); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(archive + manifestSuffix); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyArchive(archive); err == nil {
+		t.Error("expected an archive without manifest to fail verification")
+	}
+}
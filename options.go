@@ -0,0 +1,211 @@
+package loggeradapter
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	defaultFileMode = os.ModePerm
+
+	defaultArchiveAttempts   = 3
+	defaultArchiveBackoff    = time.Second
+	defaultArchiveMaxBackoff = 30 * time.Second
+	defaultArchiveInterval   = time.Minute
+	defaultArchiveJitter     = 10 * time.Second
+)
+
+// Clock provides the current time to the rotator and archiver.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc adapts a function to the Clock interface.
+type ClockFunc func() time.Time
+
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// ErrorHandler receives errors from background operations such as archiving,
+// op names the operation that failed. It may be called from several
+// goroutines at once.
+type ErrorHandler func(op string, err error)
+
+func stderrErrorHandler(op string, err error) {
+	_, _ = fmt.Fprintf(os.Stderr, "loggeradapter: %s failed: %v\n", op, err)
+}
+
+// Option configures optional behaviour of a Writer.
+type Option func(*options)
+
+type options struct {
+	fileMode        os.FileMode
+	clock           Clock
+	location        *time.Location
+	errorHandler    ErrorHandler
+	archiveRetry    retryPolicy
+	archiveInterval time.Duration
+	archiveJitter   time.Duration
+	signingKey      ed25519.PrivateKey
+	keys            KeyProvider
+	pruneHandler    PruneHandler
+	archiveStore    ArchiveStore
+	archiveUploader ArchiveUploader
+	async           *AsyncConfig
+
+	archiveOnShutdown bool
+}
+
+type retryPolicy struct {
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+func newOptions(opts ...Option) options {
+	o := options{
+		fileMode: defaultFileMode,
+		clock:    systemClock{},
+		location: time.Local,
+		archiveRetry: retryPolicy{
+			attempts:   defaultArchiveAttempts,
+			backoff:    defaultArchiveBackoff,
+			maxBackoff: defaultArchiveMaxBackoff,
+		},
+		archiveInterval: defaultArchiveInterval,
+		archiveJitter:   defaultArchiveJitter,
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&o)
+		}
+	}
+
+	return o
+}
+
+// WithFileMode sets the permission bits used when creating log files and archives.
+func WithFileMode(mode os.FileMode) Option {
+	return func(o *options) {
+		if mode != 0 {
+			o.fileMode = mode
+		}
+	}
+}
+
+// WithClock replaces the clock used for rotation and retention decisions.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		if clock != nil {
+			o.clock = clock
+		}
+	}
+}
+
+// WithErrorHandler sets the handler for errors raised by background archiving,
+// it takes precedence over Config.ErrorHandler.
+func WithErrorHandler(handler ErrorHandler) Option {
+	return func(o *options) {
+		o.errorHandler = handler
+	}
+}
+
+// WithAsync makes Write copy logs into a bounded buffer that a flusher
+// goroutine writes to the file, so disk latency stays off the caller. Sync,
+// Rotate, Reopen and Close write the buffer out first.
+func WithAsync(cfg AsyncConfig) Option {
+	return func(o *options) {
+		o.async = &cfg
+	}
+}
+
+// WithArchiveOnShutdown runs one last archive pass when the writer is shut down.
+func WithArchiveOnShutdown() Option {
+	return func(o *options) {
+		o.archiveOnShutdown = true
+	}
+}
+
+// WithArchiveRetry sets how many times an archive pass is attempted before the
+// error is reported, the wait between attempts starts at backoff and doubles
+// up to maxBackoff.
+func WithArchiveRetry(attempts int, backoff, maxBackoff time.Duration) Option {
+	return func(o *options) {
+		if attempts < 1 {
+			attempts = 1
+		}
+		o.archiveRetry = retryPolicy{attempts: attempts, backoff: backoff, maxBackoff: maxBackoff}
+	}
+}
+
+// WithArchiveInterval sets how often archive passes run in the background,
+// 1m by default, besides the pass at startup and the passes after rotations.
+// Each wait is moved by up to jitter either way, so writers started together
+// don't scan their directories at the same time. An interval of 0 disables
+// the periodic passes.
+func WithArchiveInterval(interval, jitter time.Duration) Option {
+	return func(o *options) {
+		if interval < 0 {
+			interval = 0
+		}
+		if jitter < 0 || jitter > interval {
+			jitter = interval
+		}
+		o.archiveInterval = interval
+		o.archiveJitter = jitter
+	}
+}
+
+// WithArchiveSigning signs the manifest of every archive with the Ed25519
+// private key, VerifyChain checks the signatures with the public key.
+func WithArchiveSigning(key ed25519.PrivateKey) Option {
+	return func(o *options) {
+		o.signingKey = key
+	}
+}
+
+// WithArchiveEncryption encrypts archives with AES-256-GCM using the current
+// key of the provider, VerifyArchive, VerifyChain and OpenArchive take the
+// provider to decrypt them.
+func WithArchiveEncryption(keys KeyProvider) Option {
+	return func(o *options) {
+		o.keys = keys
+	}
+}
+
+// WithPruneHandler sets the handler receiving the backups and archives
+// removed to satisfy Config.MaxTotalSize and Config.MinFreeSpace.
+func WithPruneHandler(handler PruneHandler) Option {
+	return func(o *options) {
+		o.pruneHandler = handler
+	}
+}
+
+// WithArchiveStore writes archives to the store and prunes them from it
+// instead of the local directory given by Config.ArchiveDir. Archives are
+// built in a hidden staging directory next to the log file and uploaded
+// before their backups are removed.
+func WithArchiveStore(store ArchiveStore) Option {
+	return func(o *options) {
+		o.archiveStore = store
+	}
+}
+
+// WithArchiveUploader ships every archive and its sidecars with the uploader
+// once it's written, archives stay in the local store and are pruned there.
+// Failed uploads are retried by the following archive passes.
+func WithArchiveUploader(uploader ArchiveUploader) Option {
+	return func(o *options) {
+		o.archiveUploader = uploader
+	}
+}
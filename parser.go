@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -43,7 +44,8 @@ const (
 
 // ParseExpression match and parse expression
 //
-// rotation: [y/M/w/d/h/m/s] / [b/kb/mb/gb/tb] / [annually|monthly|weekly|daily|hourly|minutely|secondly]
+// rotation: [y/M/w/d/h/m/s] / [b/kb/mb/gb/tb] / [annually|monthly|weekly|daily|hourly|minutely|secondly],
+// see ParseRotation for combined and cron rotations
 //
 // retain/archive: [y/M/w/d/h/m/s] / [<number>]
 func ParseExpression(expression string) (int, string, error) {
@@ -70,6 +72,51 @@ func ParseExpression(expression string) (int, string, error) {
 	return v, "", nil
 }
 
+// ParseRotation splits a rotation expression into its time interval and file
+// size terms, e.g. `daily,500mb` rotates daily or when the file reaches 500mb,
+// whichever comes first. The interval may also be a cron expression such as
+// `0 2 * * 1-5,500mb`. Either term may be empty.
+func ParseRotation(expression string) (interval, size string, err error) {
+	if expression == "" {
+		return "", "", nil
+	}
+
+	var rest []string
+	for _, term := range strings.Split(expression, ",") {
+		term = strings.TrimSpace(term)
+
+		if _, unit, err := ParseExpression(term); err == nil && IsFileSize(unit) {
+			if size != "" {
+				return "", "", fmt.Errorf("duplicated rotation term: %s", term)
+			}
+			size = term
+			continue
+		}
+		rest = append(rest, term)
+	}
+
+	if len(rest) == 0 {
+		return "", size, nil
+	}
+
+	interval = strings.Join(rest, ",")
+	if IsCron(interval) {
+		if _, err = ParseCron(interval); err != nil {
+			return "", "", err
+		}
+		return interval, size, nil
+	}
+
+	if len(rest) > 1 {
+		return "", "", fmt.Errorf("duplicated rotation term: %s", interval)
+	}
+	if _, unit, err := ParseExpression(interval); err != nil || !IsDuration(unit) {
+		return "", "", fmt.Errorf("invalid rotation term: %s", interval)
+	}
+
+	return interval, size, nil
+}
+
 func parseExpression(regexpPattern string, expression string) ([]string, error) {
 	regex, err := regexp.Compile(regexpPattern)
 	if err != nil {
@@ -55,3 +55,37 @@ var testStrings = []string{
 	"Annually", "Monthly", "Weekly", "Daily", "Hourly", "Minutely", "Secondly", // 匹配
 	"year", "Month", "Week", "Day", "Hour", "Minute", "Second", // 不匹配
 }
+
+func TestParseRotation(t *testing.T) {
+	cases := []struct {
+		expression, interval, size string
+		invalid                    bool
+	}{
+		{expression: "daily", interval: "daily"},
+		{expression: "500mb", size: "500mb"},
+		{expression: "daily,500mb", interval: "daily", size: "500mb"},
+		{expression: "500mb, 1h", interval: "1h", size: "500mb"},
+		{expression: "daily,1h", invalid: true},
+		{expression: "10mb,1gb", invalid: true},
+		{expression: "daily,10", invalid: true},
+		{expression: "0 2 * * 1-5", interval: "0 2 * * 1-5"},
+		{expression: "0,30 * * * *,500mb", interval: "0,30 * * * *", size: "500mb"},
+		{expression: "@midnight,1gb", interval: "@midnight", size: "1gb"},
+		{expression: "0 25 * * *", invalid: true},
+		{expression: "0 0 30 2 *", invalid: true},
+		{expression: "0 0 29 2 *", interval: "0 0 29 2 *"},
+	}
+
+	for _, c := range cases {
+		interval, size, err := ParseRotation(c.expression)
+		if c.invalid {
+			if err == nil {
+				t.Errorf("%s: expected error", c.expression)
+			}
+			continue
+		}
+		if err != nil || interval != c.interval || size != c.size {
+			t.Errorf("%s: got (%q, %q, %v)", c.expression, interval, size, err)
+		}
+	}
+}
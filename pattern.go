@@ -0,0 +1,491 @@
+package loggeradapter
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultBackupPattern = "{dir}/{prefix}-{time}{ext}"
+
+// layoutElements maps Go time layout elements to the regexp matching them,
+// longer elements come first so they win over their prefixes.
+var layoutElements = []struct{ layout, regexp string }{
+	{"January", `[A-Za-z]+`},
+	{"Jan", `[A-Za-z]{3}`},
+	{"Monday", `[A-Za-z]+`},
+	{"Mon", `[A-Za-z]{3}`},
+	{"MST", `[A-Za-z]+`},
+	{"2006", `\d{4}`},
+	{"002", `\d{3}`},
+	{"__2", `[ \d]{2}\d`},
+	{"_2", `[ \d]\d`},
+	{"Z07:00", `(?:Z|[+-]\d{2}:\d{2})`},
+	{"Z0700", `(?:Z|[+-]\d{4})`},
+	{"-07:00", `[+-]\d{2}:\d{2}`},
+	{"-0700", `[+-]\d{4}`},
+	{"-07", `[+-]\d{2}`},
+	{"01", `\d{2}`},
+	{"02", `\d{2}`},
+	{"03", `\d{2}`},
+	{"04", `\d{2}`},
+	{"05", `\d{2}`},
+	{"06", `\d{2}`},
+	{"15", `\d{2}`},
+	{"PM", `[AP]M`},
+	{"pm", `[ap]m`},
+	{"1", `\d{1,2}`},
+	{"2", `\d{1,2}`},
+	{"3", `\d{1,2}`},
+	{"4", `\d{1,2}`},
+	{"5", `\d{1,2}`},
+}
+
+var strftimeDirectives = map[byte]string{
+	'Y': "2006",
+	'y': "06",
+	'm': "01",
+	'b': "Jan",
+	'B': "January",
+	'd': "02",
+	'j': "002",
+	'a': "Mon",
+	'A': "Monday",
+	'H': "15",
+	'I': "03",
+	'p': "PM",
+	'M': "04",
+	'S': "05",
+	'z': "-0700",
+	'Z': "MST",
+}
+
+type segmentKind int
+
+const (
+	literalSegment segmentKind = iota
+	timeSegment
+	seqSegment
+)
+
+type patternSegment struct {
+	kind  segmentKind
+	value string // literal text or time layout
+}
+
+// filePattern generates and recognises the names of backup files from a
+// template such as {dir}/{prefix}.{time:20060102-150405}.{seq}{ext} or
+// %Y/%m/%d/app-%H.log, relative templates are resolved against the directory
+// of the log file.
+type filePattern struct {
+	template string
+	root     string // directory the dynamic part of the template is relative to
+	segments []patternSegment
+	regexp   *regexp.Regexp
+	timeIdx  []int
+	seqIdx   int
+	depth    int  // number of directories below root
+	hasSeq   bool // the template has an explicit {seq}
+	location *time.Location
+	suffix   string // appended to generated names and required on parsed ones
+
+	// suffixes returns all suffixes recognised on parsed names, longest first
+	suffixes func() []string
+
+	implicitSeq, implicitSeqAt int // segment and offset of the implicit .N
+}
+
+func newFilePattern(template, filename, defaultLayout string, location *time.Location) (*filePattern, error) {
+	if template == "" {
+		template = defaultBackupPattern
+	}
+	if location == nil {
+		location = time.Local
+	}
+
+	dir := filepath.ToSlash(filepath.Dir(filename))
+	prefix, ext := prefixAndExt(filename)
+
+	expanded := translateStrftime(template)
+	if !strings.HasPrefix(expanded, "{dir}") && !path.IsAbs(filepath.ToSlash(expanded)) {
+		expanded = "{dir}/" + expanded
+	}
+	expanded = strings.NewReplacer("{dir}", dir, "{prefix}", prefix, "{ext}", ext).Replace(filepath.ToSlash(expanded))
+
+	segments, err := parseSegments(expanded, defaultLayout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %s: %v", template, err)
+	}
+
+	p := &filePattern{template: template, seqIdx: -1, location: location}
+
+	// the literal directories before the first dynamic segment are the root
+	if segments[0].kind == literalSegment {
+		literal := segments[0].value
+		if i := strings.LastIndex(literal, "/"); i >= 0 {
+			p.root = filepath.Clean(filepath.FromSlash(literal[:i+1]))
+			segments[0].value = literal[i+1:]
+		}
+	}
+	if p.root == "" {
+		p.root = "."
+	}
+
+	p.segments = segments
+	if err = p.compile(); err != nil {
+		return nil, fmt.Errorf("invalid pattern %s: %v", template, err)
+	}
+
+	return p, nil
+}
+
+// translateStrftime converts strftime directives such as %Y into {time:2006}.
+func translateStrftime(template string) string {
+	if !strings.Contains(template, "%") {
+		return template
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		if c != '%' || i+1 == len(template) {
+			b.WriteByte(c)
+			continue
+		}
+
+		i++
+		if template[i] == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		if layout, ok := strftimeDirectives[template[i]]; ok {
+			b.WriteString("{time:" + layout + "}")
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(template[i])
+	}
+
+	return b.String()
+}
+
+func parseSegments(template, defaultLayout string) ([]patternSegment, error) {
+	var segments []patternSegment
+	hasTime := false
+
+	for len(template) > 0 {
+		start := strings.Index(template, "{")
+		if start < 0 {
+			segments = appendLiteral(segments, template)
+			break
+		}
+
+		end := strings.Index(template[start:], "}")
+		if end < 0 {
+			return nil, errors.New("unclosed placeholder")
+		}
+		end += start
+
+		segments = appendLiteral(segments, template[:start])
+
+		placeholder := template[start+1 : end]
+		switch {
+		case placeholder == "time":
+			if defaultLayout == "" {
+				return nil, errors.New("{time} needs a layout")
+			}
+			segments = append(segments, patternSegment{kind: timeSegment, value: defaultLayout})
+			hasTime = true
+		case strings.HasPrefix(placeholder, "time:"):
+			layout := strings.TrimPrefix(placeholder, "time:")
+			if layout == "" {
+				return nil, errors.New("empty time layout")
+			}
+			segments = append(segments, patternSegment{kind: timeSegment, value: layout})
+			hasTime = true
+		case placeholder == "seq":
+			segments = append(segments, patternSegment{kind: seqSegment})
+		default:
+			return nil, fmt.Errorf("unknown placeholder {%s}", placeholder)
+		}
+
+		template = template[end+1:]
+	}
+
+	if !hasTime {
+		return nil, errors.New("missing {time} placeholder")
+	}
+
+	return segments, nil
+}
+
+func appendLiteral(segments []patternSegment, literal string) []patternSegment {
+	if literal == "" {
+		return segments
+	}
+	if n := len(segments); n > 0 && segments[n-1].kind == literalSegment {
+		segments[n-1].value += literal
+		return segments
+	}
+	return append(segments, patternSegment{kind: literalSegment, value: literal})
+}
+
+func (p *filePattern) compile() error {
+	// without an explicit {seq}, an implicit .N goes before the extension of
+	// the last literal, e.g. log-2024-01-01.1.log, or at the end of the name
+	p.implicitSeq = -1
+	for _, s := range p.segments {
+		if s.kind == seqSegment {
+			if p.hasSeq {
+				return errors.New("duplicated {seq} placeholder")
+			}
+			p.hasSeq = true
+		}
+	}
+	if last := len(p.segments) - 1; !p.hasSeq && p.segments[last].kind == literalSegment {
+		literal := p.segments[last].value
+		if dot := strings.LastIndex(literal, "."); dot >= 0 && !strings.Contains(literal[dot:], "/") {
+			p.implicitSeq, p.implicitSeqAt = last, dot
+		}
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+
+	group := 0
+	for i, s := range p.segments {
+		switch s.kind {
+		case literalSegment:
+			if i == p.implicitSeq {
+				expr.WriteString(regexp.QuoteMeta(s.value[:p.implicitSeqAt]))
+				expr.WriteString(`(?:\.(\d+))?`)
+				expr.WriteString(regexp.QuoteMeta(s.value[p.implicitSeqAt:]))
+				group++
+				p.seqIdx = group
+				continue
+			}
+			expr.WriteString(regexp.QuoteMeta(s.value))
+		case timeSegment:
+			expr.WriteString("(" + layoutRegexp(s.value) + ")")
+			group++
+			p.timeIdx = append(p.timeIdx, group)
+		case seqSegment:
+			expr.WriteString(`(\d+)`)
+			group++
+			p.seqIdx = group
+		}
+	}
+
+	for _, s := range p.segments {
+		p.depth += strings.Count(s.value, "/")
+	}
+
+	if !p.hasSeq && p.implicitSeq < 0 {
+		expr.WriteString(`(?:\.(\d+))?`)
+		group++
+		p.seqIdx = group
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return err
+	}
+	p.regexp = re
+	return nil
+}
+
+// layoutRegexp converts a Go time layout into a regexp matching its output.
+func layoutRegexp(layout string) string {
+	var b strings.Builder
+
+	for len(layout) > 0 {
+		if (layout[0] == '.' || layout[0] == ',') && len(layout) > 1 && (layout[1] == '0' || layout[1] == '9') {
+			n := 1
+			for n < len(layout) && layout[n] == layout[1] {
+				n++
+			}
+			if layout[1] == '0' {
+				b.WriteString(fmt.Sprintf(`[.,]\d{%d}`, n-1))
+			} else {
+				b.WriteString(`(?:[.,]\d+)?`)
+			}
+			layout = layout[n:]
+			continue
+		}
+
+		matched := false
+		for _, e := range layoutElements {
+			if strings.HasPrefix(layout, e.layout) {
+				b.WriteString(e.regexp)
+				layout = layout[len(e.layout):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			b.WriteString(regexp.QuoteMeta(layout[:1]))
+			layout = layout[1:]
+		}
+	}
+
+	return b.String()
+}
+
+// format renders the file name for time t and sequence number seq.
+func (p *filePattern) format(t time.Time, seq int) string {
+	var b strings.Builder
+	t = t.In(p.location)
+
+	for i, s := range p.segments {
+		switch s.kind {
+		case literalSegment:
+			if i == p.implicitSeq && seq > 0 {
+				b.WriteString(s.value[:p.implicitSeqAt] + "." + strconv.Itoa(seq) + s.value[p.implicitSeqAt:])
+				continue
+			}
+			b.WriteString(s.value)
+		case timeSegment:
+			b.WriteString(t.Format(s.value))
+		case seqSegment:
+			b.WriteString(strconv.Itoa(seq))
+		}
+	}
+
+	if !p.hasSeq && p.implicitSeq < 0 && seq > 0 {
+		b.WriteString("." + strconv.Itoa(seq))
+	}
+	b.WriteString(p.suffix)
+
+	return filepath.Join(p.root, filepath.FromSlash(b.String()))
+}
+
+// next returns the first name for time t that doesn't exist yet.
+func (p *filePattern) next(t time.Time) string {
+	filename := p.format(t, 0)
+	for seq := 1; fileExists(filename); seq++ {
+		filename = p.format(t, seq)
+	}
+	return filename
+}
+
+// parse extracts the time and sequence number from a path relative to root.
+func (p *filePattern) parse(rel string) (time.Time, int, bool) {
+	return p.parseSuffixed(rel, p.suffixList())
+}
+
+func (p *filePattern) suffixList() []string {
+	if p.suffixes != nil {
+		return p.suffixes()
+	}
+	return []string{p.suffix}
+}
+
+func (p *filePattern) parseSuffixed(rel string, suffixes []string) (time.Time, int, bool) {
+	matched := false
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(rel, suffix) {
+			rel, matched = rel[:len(rel)-len(suffix)], true
+			break
+		}
+	}
+	if !matched {
+		return time.Time{}, 0, false
+	}
+
+	m := p.regexp.FindStringSubmatch(filepath.ToSlash(rel))
+	if m == nil {
+		return time.Time{}, 0, false
+	}
+
+	layouts := make([]string, 0, len(p.timeIdx))
+	values := make([]string, 0, len(p.timeIdx))
+	j := 0
+	for _, s := range p.segments {
+		if s.kind == timeSegment {
+			layouts = append(layouts, s.value)
+			values = append(values, m[p.timeIdx[j]])
+			j++
+		}
+	}
+
+	t, err := time.ParseInLocation(strings.Join(layouts, "\x1f"), strings.Join(values, "\x1f"), p.location)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+
+	seq := 0
+	if p.seqIdx > 0 && m[p.seqIdx] != "" {
+		if seq, err = strconv.Atoi(m[p.seqIdx]); err != nil {
+			return time.Time{}, 0, false
+		}
+	}
+
+	return t, seq, true
+}
+
+// scan walks the root of the pattern and returns the matching files,
+// newest first.
+func (p *filePattern) scan() ([]logInfo, error) {
+	var files []logInfo
+	suffixes := p.suffixList()
+
+	err := filepath.WalkDir(p.root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if name == p.root {
+				return err
+			}
+			return nil
+		}
+
+		rel, relErr := filepath.Rel(p.root, name)
+		if relErr != nil || rel == "." {
+			return nil
+		}
+
+		if d.IsDir() {
+			if strings.Count(filepath.ToSlash(rel), "/") >= p.depth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		t, seq, ok := p.parseSuffixed(rel, suffixes)
+		if !ok {
+			return nil
+		}
+
+		fileInfo, infoErr := d.Info()
+		if infoErr != nil {
+			return nil
+		}
+
+		files = append(files, logInfo{timestamp: t, seq: seq, path: name, rel: filepath.ToSlash(rel), FileInfo: fileInfo})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't read log file directory: %w", err)
+	}
+
+	sort.Sort(byFormatTime(files))
+	return files, nil
+}
+
+// removeEmptyDirs removes the empty parent directories of name below root.
+func (p *filePattern) removeEmptyDirs(name string) {
+	root := filepath.Clean(p.root)
+
+	for dir := filepath.Dir(name); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
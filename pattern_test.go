@@ -0,0 +1,144 @@
+package loggeradapter
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFilePatternParse(t *testing.T) {
+	cases := []struct {
+		template, layout, rel string
+		seq                   int
+		invalid               bool
+	}{
+		{layout: "2006-01-02", rel: "log-2024-01-01.log"},
+		{layout: "2006-01-02", rel: "log-2024-01-01.2.log", seq: 2},
+		{layout: defaultTimeFormat, rel: "log-2024-01-01T10-10-10.123.log"},
+		{layout: defaultTimeFormat, rel: "log-2024-01-01T10-10-10.123.1.log", seq: 1},
+		{layout: "2006-01-02", rel: "log-2024-01-01.x.log", invalid: true},
+		{layout: "2006-01-02", rel: "app-2024-01-01.log", invalid: true},
+		{layout: "2006-01-02", rel: "log.log", invalid: true},
+		{template: "{prefix}.{time:20060102-150405}.{seq}{ext}", rel: "log.20240101-101010.0.log"},
+		{template: "{prefix}.{time:20060102-150405}.{seq}{ext}", rel: "log.20240101-101010.3.log", seq: 3},
+		{template: "{prefix}.{time:20060102-150405}.{seq}{ext}", rel: "log.20240101-101010.log", invalid: true},
+		{template: "%Y/%m/%d/app-%H.log", rel: "2024/01/01/app-10.log"},
+		{template: "%Y/%m/%d/app-%H.log", rel: "2024/01/01/app-10.1.log", seq: 1},
+		{template: "%Y/%m/%d/app-%H.log", rel: "2024/01/app-10.log", invalid: true},
+	}
+
+	for _, c := range cases {
+		p, err := newFilePattern(c.template, "logs/log.log", c.layout, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, seq, ok := p.parse(c.rel)
+		if c.invalid {
+			if ok {
+				t.Errorf("%s %s: expected no match", c.template, c.rel)
+			}
+			continue
+		}
+		if !ok || seq != c.seq {
+			t.Errorf("%s %s: got seq %d, match %v", c.template, c.rel, seq, ok)
+		}
+	}
+}
+
+func TestFilePatternFormat(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 10, 10, 0, time.UTC)
+
+	cases := []struct {
+		template, layout string
+		seq              int
+		filename         string
+		parsed           time.Time
+	}{
+		{layout: "2006-01-02", filename: "logs/log-2024-01-01.log",
+			parsed: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{layout: "2006-01-02", seq: 2, filename: "logs/log-2024-01-01.2.log",
+			parsed: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{template: "{dir}/{prefix}.{time:20060102-150405}.{seq}{ext}", filename: "logs/log.20240101-101010.0.log",
+			parsed: now},
+		{template: "%Y/%m/%d/app-%H.log", filename: "logs/2024/01/01/app-10.log",
+			parsed: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{template: "archive/{prefix}-{time:2006}", seq: 1, filename: "logs/archive/log-2024.1",
+			parsed: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		p, err := newFilePattern(c.template, "logs/log.log", c.layout, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if filename := p.format(now, c.seq); filename != filepath.FromSlash(c.filename) {
+			t.Errorf("%s: got %s, want %s", c.template, filename, c.filename)
+		}
+
+		rel, _ := filepath.Rel(p.root, filepath.FromSlash(c.filename))
+		if parsed, seq, ok := p.parse(rel); !ok || !parsed.Equal(c.parsed) || seq != c.seq {
+			t.Errorf("%s: can't parse back %s: %v %d %v", c.template, c.filename, parsed, seq, ok)
+		}
+	}
+}
+
+func TestInvalidFilePattern(t *testing.T) {
+	for _, template := range []string{"{prefix}{ext}", "{prefix}-{time", "{prefix}-{time}-{name}", "{time}{seq}{seq}"} {
+		if _, err := newFilePattern(template, "log.log", defaultTimeFormat, nil); err == nil {
+			t.Errorf("%s: expected error", template)
+		}
+	}
+}
+
+func TestWriterBackupPatternSubdirectories(t *testing.T) {
+	dir := t.TempDir()
+
+	var mu sync.Mutex
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+	clock := ClockFunc(func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	})
+
+	w, err := NewWriter(Config{
+		Filename:      filepath.Join(dir, "app.log"),
+		Rotation:      "hourly",
+		BackupPattern: "%Y/%m/%d/app-%H.log",
+		Backup:        "1h",
+		Archive:       "5",
+	}, WithClock(clock), WithArchiveOnShutdown())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = w.Write([]byte("10 o'clock\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "2024", "01", "01", "app-10.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "10 o'clock\n" {
+		t.Errorf("unexpected backup content %q", content)
+	}
+
+	mu.Lock()
+	now = now.Add(2 * time.Hour)
+	mu.Unlock()
+
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "2024")); !os.IsNotExist(err) {
+		t.Errorf("expected the archived backup directories to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package loggeradapter
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// PruneReason is the constraint a file was removed for.
+type PruneReason string
+
+const (
+	PruneMaxTotalSize PruneReason = "max-total-size"
+	PruneMinFreeSpace PruneReason = "min-free-space"
+)
+
+// PruneEvent reports a backup or archive removed to satisfy MaxTotalSize or
+// MinFreeSpace, TotalSize and FreeSpace are measured before the removal.
+type PruneEvent struct {
+	Path      string
+	Size      int64
+	Reason    PruneReason
+	TotalSize int64
+	FreeSpace int64
+}
+
+// PruneHandler receives the files removed to satisfy MaxTotalSize and MinFreeSpace.
+type PruneHandler func(PruneEvent)
+
+// parseSizeLimit parses a file size such as 5gb, an empty limit is 0.
+func parseSizeLimit(field, expression string) (int64, error) {
+	if expression == "" {
+		return 0, nil
+	}
+
+	v, unit, err := ParseExpression(expression)
+	if err != nil {
+		return 0, &ConfigError{Field: field, Value: expression, Reason: err.Error()}
+	}
+	if !IsFileSize(unit) {
+		return 0, &ConfigError{Field: field, Value: expression, Reason: "must be a file size"}
+	}
+	if v <= 0 {
+		return 0, &ConfigError{Field: field, Value: expression, Reason: "must be greater than zero"}
+	}
+	return fileSizeBytes(v, unit), nil
+}
+
+// diskFree and sameFileSystem are replaced in tests.
+var (
+	diskFree       = statDiskFree
+	sameFileSystem = statSameFileSystem
+)
+
+// pruneBySize removes the oldest archives, then the oldest backups, until the
+// logs take at most maxTotalSize and the file system keeps minFreeSpace free.
+// The active log file is never removed.
+func (a *archiver) pruneBySize() error {
+	if a.maxTotalSize == 0 && a.minFreeSpace == 0 {
+		return nil
+	}
+
+	// archives in a remote store don't take space on this file system
+	var (
+		archives []logInfo
+		err      error
+	)
+	if a.local != nil {
+		if archives, err = a.indexedArchives(); err != nil {
+			return err
+		}
+	}
+	backups, err := a.indexedBackups()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	var total int64
+	if fi, err := os.Stat(a.filename); err == nil {
+		total += fi.Size()
+	}
+	for _, f := range archives {
+		total += f.Size()
+	}
+	for _, f := range backups {
+		total += f.Size()
+	}
+
+	free := int64(-1)
+	// archives in an ArchiveDir on another file system free no space here
+	archivesFree := true
+	if a.minFreeSpace > 0 {
+		if free, err = diskFree(filepath.Dir(a.filename)); err != nil {
+			return err
+		}
+		if len(archives) > 0 {
+			archivesFree = sameFileSystem(a.local.dir, filepath.Dir(a.filename))
+		}
+	}
+
+	// oldest first: archives, then backups
+	candidates := make([]logInfo, 0, len(archives)+len(backups))
+	for i := len(archives) - 1; i >= 0; i-- {
+		candidates = append(candidates, archives[i])
+	}
+	for i := len(backups) - 1; i >= 0; i-- {
+		candidates = append(candidates, backups[i])
+	}
+
+	prunedArchives := false
+prune:
+	for i, f := range candidates {
+		var reason PruneReason
+		isArchive := i < len(archives)
+		switch {
+		case a.maxTotalSize > 0 && total > a.maxTotalSize:
+			reason = PruneMaxTotalSize
+		case a.minFreeSpace > 0 && free < a.minFreeSpace:
+			if isArchive && !archivesFree {
+				continue
+			}
+			reason = PruneMinFreeSpace
+		default:
+			break prune
+		}
+
+		if isArchive {
+			err = a.deleteArchive(f.rel)
+			prunedArchives = true
+		} else {
+			err = os.Remove(f.path)
+			a.forgetBackup(f.path)
+			a.backups.removeEmptyDirs(f.path)
+		}
+		if err != nil {
+			return err
+		}
+
+		if a.pruneHandler != nil {
+			a.pruneHandler(PruneEvent{Path: f.path, Size: f.Size(), Reason: reason, TotalSize: total, FreeSpace: free})
+		}
+
+		total -= f.Size()
+		if free >= 0 && (!isArchive || archivesFree) {
+			free += f.Size()
+		}
+	}
+
+	// the oldest archives went, and with them the need for older pruned sidecars
+	if prunedArchives {
+		return a.dropPruned()
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package loggeradapter
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func pruneFixture(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for _, name := range []string{
+		"app.log",
+		"app-2024-01-01T10.log",
+		"app-2024-01-01T11.log",
+		"app-2024-01-01T08-00-00.tar.gz",
+		"app-2024-01-01T09-00-00.tar.gz",
+		"app-2024-01-01T09-30-00.tar.gz",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), bytes.Repeat([]byte{'x'}, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func runPrune(t *testing.T, dir string, cfg Config) []PruneEvent {
+	t.Helper()
+
+	var (
+		mu     sync.Mutex
+		events []PruneEvent
+	)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	cfg.Filename = filepath.Join(dir, "app.log")
+	cfg.Rotation = "1h"
+
+	w, err := NewWriter(cfg, WithClock(ClockFunc(func() time.Time { return now })), WithArchiveOnShutdown(),
+		WithPruneHandler(func(e PruneEvent) {
+			mu.Lock()
+			events = append(events, e)
+			mu.Unlock()
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestPruneMaxTotalSize(t *testing.T) {
+	dir := pruneFixture(t)
+	events := runPrune(t, dir, Config{MaxTotalSize: "250b"})
+
+	want := []string{
+		"app-2024-01-01T08-00-00.tar.gz",
+		"app-2024-01-01T09-00-00.tar.gz",
+		"app-2024-01-01T09-30-00.tar.gz",
+		"app-2024-01-01T10.log",
+	}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d files to be pruned, got %+v", len(want), events)
+	}
+	for i, e := range events {
+		if filepath.Base(e.Path) != want[i] || e.Reason != PruneMaxTotalSize || e.TotalSize != int64(600-100*i) {
+			t.Errorf("unexpected event %+v", e)
+		}
+	}
+
+	for _, name := range []string{"app.log", "app-2024-01-01T11.log"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s to be kept: %v", name, err)
+		}
+	}
+}
+
+func TestPruneMinFreeSpace(t *testing.T) {
+	dir := pruneFixture(t)
+
+	// every removed file frees its 100 bytes, so the pass at startup prunes
+	// and the pass at shutdown finds enough space
+	defer func(f func(string) (int64, error)) { diskFree = f }(diskFree)
+	diskFree = func(string) (int64, error) {
+		entries, err := os.ReadDir(dir)
+		return 1000 + 100*int64(6-len(entries)), err
+	}
+	events := runPrune(t, dir, Config{MinFreeSpace: "1150b"})
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 archives to be pruned, got %+v", events)
+	}
+	for i, e := range events {
+		if e.Reason != PruneMinFreeSpace || e.FreeSpace != int64(1000+100*i) {
+			t.Errorf("unexpected event %+v", e)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "app-2024-01-01T09-30-00.tar.gz")); err != nil {
+		t.Errorf("expected the newest archive to be kept: %v", err)
+	}
+}
+
+func TestPruneMinFreeSpaceArchiveDir(t *testing.T) {
+	dir := pruneFixture(t)
+	archiveDir := filepath.Join(dir, "archive")
+	if err := os.Mkdir(archiveDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	archives, _ := filepath.Glob(filepath.Join(dir, "*.tar.gz"))
+	for _, archive := range archives {
+		if err := os.Rename(archive, filepath.Join(archiveDir, filepath.Base(archive))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// archives on another file system free nothing next to the log file
+	defer func(f func(string) (int64, error)) { diskFree = f }(diskFree)
+	defer func(f func(a, b string) bool) { sameFileSystem = f }(sameFileSystem)
+	diskFree = func(string) (int64, error) {
+		entries, err := os.ReadDir(dir)
+		return 1000 + 100*int64(4-len(entries)), err
+	}
+	sameFileSystem = func(a, b string) bool { return a != archiveDir }
+	events := runPrune(t, dir, Config{MinFreeSpace: "1150b", ArchiveDir: archiveDir})
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 backups to be pruned, got %+v", events)
+	}
+	for i, name := range []string{"app-2024-01-01T10.log", "app-2024-01-01T11.log"} {
+		if filepath.Base(events[i].Path) != name || events[i].Reason != PruneMinFreeSpace {
+			t.Errorf("unexpected event %+v", events[i])
+		}
+	}
+	if left, _ := filepath.Glob(filepath.Join(archiveDir, "*.tar.gz")); len(left) != len(archives) {
+		t.Errorf("expected the archives to be kept, got %v", left)
+	}
+}
+
+func TestSizeLimitConfigError(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "app.log")
+
+	for _, cfg := range []Config{
+		{Filename: filename, MaxTotalSize: "1d"},
+		{Filename: filename, MinFreeSpace: "0gb"},
+	} {
+		var cfgErr *ConfigError
+		if _, err := NewWriter(cfg); !errors.As(err, &cfgErr) {
+			t.Errorf("%+v: expected *ConfigError, got %v", cfg, err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package loggeradapter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// gfsRule keeps, for the files younger than the age, every file or the newest
+// file of each period.
+type gfsRule struct {
+	v      int
+	unit   string
+	bucket string
+}
+
+// gfsPolicy is a grandfather-father-son retention policy such as
+// 48h:all,30d:daily,26w:weekly,36M:monthly, a file is kept when any rule
+// keeps it.
+type gfsPolicy []gfsRule
+
+var gfsBuckets = map[string]bool{
+	"all":     true,
+	"hourly":  true,
+	"daily":   true,
+	"weekly":  true,
+	"monthly": true,
+	"yearly":  true,
+}
+
+// IsGFS reports whether a retention expression is a grandfather-father-son
+// policy rather than a count or an age.
+func IsGFS(expression string) bool {
+	return strings.Contains(expression, ":")
+}
+
+func parseGFS(expression string) (gfsPolicy, error) {
+	var policy gfsPolicy
+
+	for _, term := range strings.Split(expression, ",") {
+		age, bucket, ok := strings.Cut(strings.TrimSpace(term), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid retention term %q, expected <age>:<all|hourly|daily|weekly|monthly|yearly>", term)
+		}
+
+		bucket = strings.ToLower(strings.TrimSpace(bucket))
+		if !gfsBuckets[bucket] {
+			return nil, fmt.Errorf("invalid retention period %q in %q", bucket, term)
+		}
+
+		v, unit, err := ParseExpression(strings.TrimSpace(age))
+		if err != nil {
+			return nil, err
+		}
+		if !IsDuration(unit) || v <= 0 {
+			return nil, fmt.Errorf("invalid retention age %q in %q", age, term)
+		}
+
+		policy = append(policy, gfsRule{v: v, unit: unit, bucket: bucket})
+	}
+
+	return policy, nil
+}
+
+// expired returns the files no rule keeps, files are sorted newest first so
+// the newest file of each period is the one kept.
+func (p gfsPolicy) expired(files []logInfo, now time.Time) []logInfo {
+	kept := make([]bool, len(files))
+
+	for _, rule := range p {
+		cutoff := retentionCutoff(now, rule.v, rule.unit)
+		seen := make(map[string]bool)
+
+		for i, f := range files {
+			if f.timestamp.Before(cutoff) {
+				continue
+			}
+			if rule.bucket == "all" {
+				kept[i] = true
+				continue
+			}
+
+			key := gfsBucketKey(f.timestamp, rule.bucket)
+			if !seen[key] {
+				seen[key] = true
+				kept[i] = true
+			}
+		}
+	}
+
+	var expired []logInfo
+	for i, f := range files {
+		if !kept[i] {
+			expired = append(expired, f)
+		}
+	}
+	return expired
+}
+
+// retentionCutoff returns now minus the age, calendar units follow the calendar.
+func retentionCutoff(now time.Time, v int, unit string) time.Time {
+	switch {
+	case IsYear(unit):
+		return now.AddDate(-v, 0, 0)
+	case IsMonth(unit):
+		return now.AddDate(0, -v, 0)
+	case IsWeek(unit):
+		return now.AddDate(0, 0, -7*v)
+	case IsDay(unit):
+		return now.AddDate(0, 0, -v)
+	case IsHour(unit):
+		return now.Add(-time.Duration(v) * time.Hour)
+	case IsMinute(unit):
+		return now.Add(-time.Duration(v) * time.Minute)
+	default:
+		return now.Add(-time.Duration(v) * time.Second)
+	}
+}
+
+func gfsBucketKey(t time.Time, bucket string) string {
+	switch bucket {
+	case "hourly":
+		return t.Format("2006-01-02T15")
+	case "daily":
+		return t.Format("2006-01-02")
+	case "weekly":
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case "monthly":
+		return t.Format("2006-01")
+	default:
+		return t.Format("2006")
+	}
+}
@@ -0,0 +1,114 @@
+package loggeradapter
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGFSPolicy(t *testing.T) {
+	policy, err := parseGFS("48h:all, 30d:daily, 12w:weekly, 36M:monthly")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 6, 15, 12, 30, 0, 0, time.UTC)
+
+	// an archive every hour for a year, newest first
+	var files []logInfo
+	for ts := now.Add(-time.Hour); ts.After(now.AddDate(-1, 0, 0)); ts = ts.Add(-time.Hour) {
+		files = append(files, logInfo{timestamp: ts})
+	}
+
+	expired := make(map[time.Time]bool)
+	for _, f := range policy.expired(files, now) {
+		expired[f.timestamp] = true
+	}
+
+	days, months := make(map[string]int), make(map[string]int)
+	for _, f := range files {
+		ts := f.timestamp
+		if ts.After(now.Add(-48 * time.Hour)) {
+			if expired[ts] {
+				t.Errorf("%s is within 48h and was expired", ts)
+			}
+			continue
+		}
+		if expired[ts] {
+			continue
+		}
+		days[ts.Format("2006-01-02")]++
+		months[ts.Format("2006-01")]++
+
+		if ts.Hour() != 23 && ts.Before(now.AddDate(0, 0, -2)) && ts.After(now.AddDate(0, 0, -29)) {
+			t.Errorf("%s isn't the newest archive of its day", ts)
+		}
+	}
+
+	for day, n := range days {
+		if n > 1 {
+			t.Errorf("%d archives kept for %s", n, day)
+		}
+	}
+	// archives go back a year, so every month of it keeps one
+	if len(months) != 13 {
+		t.Errorf("expected an archive for each of the 13 months, got %v", months)
+	}
+	if len(days) < 30 || len(days) > 30+12+13 {
+		t.Errorf("unexpected number of days kept: %d", len(days))
+	}
+}
+
+func TestGFSRetention(t *testing.T) {
+	dir := t.TempDir()
+
+	names := []string{
+		"app-2024-06-15T09-00-00.tar.gz",
+		"app-2024-06-15T10-00-00.tar.gz",
+		"app-2024-06-14T09-00-00.tar.gz",
+		"app-2024-06-14T10-00-00.tar.gz",
+		"app-2024-05-01T10-00-00.tar.gz",
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app-2024-06-15T11.log"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.Local)
+	w, err := NewWriter(Config{
+		Filename: filepath.Join(dir, "app.log"),
+		Rotation: "1h",
+		Backup:   "1",
+		Archive:  "12h:all,7d:daily",
+	}, WithClock(ClockFunc(func() time.Time { return now })), WithArchiveOnShutdown())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, name := range names {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if removed := os.IsNotExist(err); removed != (i == 2 || i == 4) {
+			t.Errorf("%s: unexpected removed=%v", name, removed)
+		}
+	}
+}
+
+func TestGFSConfigError(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "app.log")
+
+	for _, archive := range []string{"48h:hourly,30d", "48h:often", "10mb:daily", "0d:daily"} {
+		var cfgErr *ConfigError
+		if _, err := NewWriter(Config{Filename: filename, Backup: "1", Archive: archive}); !errors.As(err, &cfgErr) {
+			t.Errorf("%s: expected *ConfigError, got %v", archive, err)
+		}
+	}
+}
@@ -18,6 +18,11 @@ type rotator struct {
 	v    int
 	unit string
 
+	sizeValue int
+	sizeUnit  string
+
+	schedule *CronSchedule
+
 	isDuration bool
 	isFileSize bool
 
@@ -29,32 +34,64 @@ type rotator struct {
 	isMinute bool
 	isSecond bool
 
-	timeFormat string
-	nextTime   time.Time
+	timeFormat  string
+	backups     *filePattern
+	periodStart time.Time
+	nextTime    time.Time
 
 	filename     string
 	file         *os.File
+	fileMode     os.FileMode
 	maxSizeByte  int64
 	fileSizeByte int64
-	mu           sync.Mutex
+	clock        Clock
+	location     *time.Location
+	closed       bool
+	mu           sync.Mutex // guards the file, its size, closed and the rotation times
+
+	// onRotate is called, with mu held, with the backup just made
+	onRotate func(filename string)
 }
 
-func newRotator(cfg Config) *rotator {
-	if cfg.Rotation == "" {
-		return nil
-	}
+// newRotator creates the rotator owning the log file, without Rotation it
+// never rotates on its own. A Rotation combining a time interval and a file
+// size rotates on whichever comes first.
+func newRotator(cfg Config, opts options) (*rotator, error) {
+	var (
+		v, sizeValue   int
+		unit, sizeUnit string
+	)
 
-	v, unit, err := ParseExpression(cfg.Rotation)
+	interval, size, err := ParseRotation(cfg.Rotation)
 	if err != nil {
-		panic(fmt.Sprintf("Parse rotation expression failed. error: %v", err))
+		return nil, &ConfigError{Field: "Rotation", Value: cfg.Rotation, Reason: err.Error()}
+	}
+
+	var schedule *CronSchedule
+	if IsCron(interval) {
+		if schedule, err = ParseCron(interval); err != nil {
+			return nil, &ConfigError{Field: "Rotation", Value: cfg.Rotation, Reason: err.Error()}
+		}
+	} else if interval != "" {
+		if v, unit, err = ParseExpression(interval); err != nil {
+			return nil, &ConfigError{Field: "Rotation", Value: cfg.Rotation, Reason: err.Error()}
+		}
+	}
+	if size != "" {
+		if sizeValue, sizeUnit, err = ParseExpression(size); err != nil {
+			return nil, &ConfigError{Field: "Rotation", Value: cfg.Rotation, Reason: err.Error()}
+		}
 	}
 
 	r := &rotator{
 		v:          v,
 		unit:       unit,
+		sizeValue:  sizeValue,
+		sizeUnit:   sizeUnit,
+		schedule:   schedule,
 		filename:   cfg.Filename,
-		isDuration: IsDuration(unit),
-		isFileSize: IsFileSize(unit),
+		isDuration: IsDuration(unit) || schedule != nil,
+		isFileSize: IsFileSize(sizeUnit),
 		isYear:     IsYear(unit),
 		isMonth:    IsMonth(unit),
 		isWeek:     IsWeek(unit),
@@ -62,20 +99,36 @@ func newRotator(cfg Config) *rotator {
 		isHour:     IsHour(unit),
 		isMinute:   IsMinute(unit),
 		isSecond:   IsSecond(unit),
+		fileMode:   opts.fileMode,
+		clock:      opts.clock,
+		location:   opts.location,
 	}
 
 	r.setTimeFormat()
-	r.setNextTime()
+	r.setNextTime(r.now())
+
+	r.backups, err = newFilePattern(cfg.BackupPattern, cfg.Filename, r.timeFormat, r.location)
+	if err != nil {
+		return nil, &ConfigError{Field: "BackupPattern", Value: cfg.BackupPattern, Reason: err.Error()}
+	}
 	r.setMaxSize()
 
-	return r
+	return r, nil
+}
+
+func (r *rotator) now() time.Time {
+	return r.clock.Now().In(r.location)
 }
 
 func (r *rotator) setTimeFormat() {
-	if r.isFileSize {
+	if !r.isDuration {
 		r.timeFormat = defaultTimeFormat
 		return
 	}
+	if r.schedule != nil {
+		r.timeFormat = "2006-01-02T15-04"
+		return
+	}
 	if r.isYear {
 		r.timeFormat = "2006"
 		return
@@ -102,85 +155,137 @@ func (r *rotator) setTimeFormat() {
 	}
 }
 
-func (r *rotator) setNextTime() {
-	if r.isFileSize {
+// setNextTime aligns the current period to the wall-clock period containing t
+// and sets the time of the next rotation to the end of it.
+func (r *rotator) setNextTime(t time.Time) {
+	if !r.isDuration {
 		return
 	}
 
-	now := time.Now()
+	r.periodStart, r.nextTime = r.periodBounds(t)
+}
+
+// weekEpoch is the Monday multiples of weeks are aligned to.
+var weekEpoch = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// periodBounds returns the start and end of the rotation period containing t.
+// Periods are truncated to calendar boundaries and multiples of the interval
+// are aligned within the enclosing unit, e.g. 15m rotates at :00/:15/:30/:45
+// and 6h at 00:00/06:00/12:00/18:00.
+func (r *rotator) periodBounds(t time.Time) (start, next time.Time) {
+	if r.schedule != nil {
+		start = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location())
+		return start, r.schedule.Next(t)
+	}
+
+	y, m, d := t.Date()
+	loc := t.Location()
 
 	if r.isYear {
-		r.nextTime = now.AddDate(r.v, 0, 0)
-		return
+		y0 := y - y%r.v
+		return time.Date(y0, 1, 1, 0, 0, 0, 0, loc), time.Date(y0+r.v, 1, 1, 0, 0, 0, 0, loc)
 	}
 	if r.isMonth {
-		r.nextTime = now.AddDate(0, r.v, 0)
-		return
+		m0 := time.Month((int(m)-1)/r.v*r.v + 1)
+		start = time.Date(y, m0, 1, 0, 0, 0, 0, loc)
+		return start, earliest(time.Date(y, m0+time.Month(r.v), 1, 0, 0, 0, 0, loc),
+			time.Date(y+1, 1, 1, 0, 0, 0, 0, loc))
 	}
 	if r.isWeek {
-		r.nextTime = now.AddDate(0, 0, r.v*7)
-		return
+		// weeks are counted from a fixed Monday rather than within the year,
+		// so multiples don't overlap where ISO years start
+		days := int(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Sub(weekEpoch).Hours() / 24)
+		days %= r.v * 7
+		if days < 0 {
+			days += r.v * 7
+		}
+		start = time.Date(y, m, d-days, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 0, r.v*7)
 	}
 	if r.isDay {
-		r.nextTime = now.AddDate(0, 0, r.v)
-		return
+		start = time.Date(y, m, d-(d-1)%r.v, 0, 0, 0, 0, loc)
+		return start, earliest(start.AddDate(0, 0, r.v), time.Date(y, m+1, 1, 0, 0, 0, 0, loc))
 	}
+	elapsed := time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+
 	if r.isHour {
-		du, _ := time.ParseDuration(fmt.Sprintf("%dh", r.v))
-		r.nextTime = now.Add(du)
-		return
+		// periods start at wall clock hours, so 6h stays at 00:00, 06:00,
+		// 12:00 and 18:00 across DST changes; the current hour is truncated
+		// instead, which keeps its offset when it's repeated
+		h := t.Hour()
+		hourStart := t.Add(-time.Duration(t.Minute())*time.Minute - elapsed)
+		start, next = hourStart, hourStart.Add(time.Hour)
+		if h%r.v != 0 {
+			start = time.Date(y, m, d, h-h%r.v, 0, 0, 0, loc)
+		}
+		if r.v > 1 {
+			next = time.Date(y, m, d, h-h%r.v+r.v, 0, 0, 0, loc)
+		}
+		return start, earliest(next, time.Date(y, m, d+1, 0, 0, 0, 0, loc))
 	}
+
 	if r.isMinute {
-		du, _ := time.ParseDuration(fmt.Sprintf("%dm", r.v))
-		r.nextTime = now.Add(du)
-		return
+		hourStart := t.Add(-time.Duration(t.Minute())*time.Minute - elapsed)
+		start = t.Add(-time.Duration(t.Minute()%r.v)*time.Minute - elapsed)
+		return start, earliest(start.Add(time.Duration(r.v)*time.Minute), hourStart.Add(time.Hour))
 	}
 	if r.isSecond {
-		du, _ := time.ParseDuration(fmt.Sprintf("%ds", r.v))
-		r.nextTime = now.Add(du)
-		return
+		minuteStart := t.Add(-elapsed)
+		start = t.Add(-time.Duration(t.Second()%r.v)*time.Second - time.Duration(t.Nanosecond()))
+		return start, earliest(start.Add(time.Duration(r.v)*time.Second), minuteStart.Add(time.Minute))
+	}
+
+	return t, t
+}
+
+func earliest(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
 	}
+	return a
 }
 
 func (r *rotator) setMaxSize() {
-	if r.isDuration {
+	if !r.isFileSize {
 		r.maxSizeByte = defaultMaxSizeByte
 		return
 	}
 
-	if IsByte(r.unit) {
-		r.maxSizeByte = int64(r.v)
-		return
+	r.maxSizeByte = fileSizeBytes(r.sizeValue, r.sizeUnit)
+}
+
+func fileSizeBytes(v int, unit string) int64 {
+	if IsByte(unit) {
+		return int64(v)
 	}
-	if IsKB(r.unit) {
-		r.maxSizeByte = int64(r.v * 1024)
-		return
+	if IsKB(unit) {
+		return int64(v) * 1024
 	}
-	if IsMB(r.unit) {
-		r.maxSizeByte = int64(r.v * 1024 * 1024)
-		return
+	if IsMB(unit) {
+		return int64(v) * 1024 * 1024
 	}
-	if IsGB(r.unit) {
-		r.maxSizeByte = int64(r.v * 1024 * 1024 * 1024)
-		return
+	if IsGB(unit) {
+		return int64(v) * 1024 * 1024 * 1024
 	}
-	if IsTB(r.unit) {
-		r.maxSizeByte = int64(r.v * 1024 * 1024 * 1024 * 1024)
-		return
+	if IsTB(unit) {
+		return int64(v) * 1024 * 1024 * 1024 * 1024
 	}
+	return 0
 }
 
 func (r *rotator) getNewFilename() string {
-	if r.filename == "" {
-		r.filename = defaultFilename
+	// time based backups are named after the period they cover, several
+	// rotations within the same period get a sequence number,
+	// e.g. log-2024-01-01.log, log-2024-01-01.1.log, log-2024-01-01.2.log
+	if r.isDuration {
+		return r.backups.next(r.periodStart)
 	}
+	return r.backups.next(r.now())
+}
 
-	suffix := time.Now().Format(r.timeFormat)
-
-	dir := filepath.Dir(r.filename)
-	prefix, ext := prefixAndExt(r.filename)
-
-	return filepath.Join(dir, fmt.Sprintf("%s-%s%s", prefix, suffix, ext))
+func fileExists(filename string) bool {
+	_, err := os.Lstat(filename)
+	return err == nil
 }
 
 func (r *rotator) openNewFile() error {
@@ -189,6 +294,9 @@ func (r *rotator) openNewFile() error {
 		// Copy the mode off the old logfile.
 		// move the existing file
 		newFilename := r.getNewFilename()
+		if err = os.MkdirAll(filepath.Dir(newFilename), os.ModePerm); err != nil {
+			return fmt.Errorf("can't make backup directory: %s", err)
+		}
 		if err = os.Rename(r.filename, newFilename); err != nil {
 			return fmt.Errorf("can't rename log file: %s", err)
 		}
@@ -196,18 +304,112 @@ func (r *rotator) openNewFile() error {
 		if err = chown(r.filename, info); err != nil {
 			return err
 		}
+		if r.onRotate != nil {
+			defer r.onRotate(newFilename)
+		}
 	}
 
-	r.file, err = openFile(r.filename)
+	r.file, err = openFile(r.filename, r.fileMode)
 	if err != nil {
 		return fmt.Errorf("can't open new logfile: %s", err)
 	}
 
-	r.setNextTime()
+	r.setNextTime(r.now())
 	r.fileSizeByte = 0
 	return nil
 }
 
+func (r *rotator) open() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	file, err := openFile(r.filename, r.fileMode)
+	if err != nil {
+		return fmt.Errorf("can't open logfile: %s", err)
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("can't stat logfile: %s", err)
+	}
+
+	r.file = file
+	r.fileSizeByte = fi.Size()
+
+	// an existing log file covers the period it was last written in,
+	// so it's rotated on the first write after that period ends
+	if fi.Size() > 0 && fi.ModTime().Before(r.periodStart) {
+		r.setNextTime(fi.ModTime().In(r.location))
+	}
+	return nil
+}
+
+// rotate renames the current log file to a backup and opens a new one.
+func (r *rotator) rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return os.ErrClosed
+	}
+	if err := r.close(); err != nil {
+		return err
+	}
+	return r.openNewFile()
+}
+
+// reopen closes and reopens the log file without renaming it, it's used after
+// external tools such as logrotate moved the file away.
+func (r *rotator) reopen() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return os.ErrClosed
+	}
+	if err := r.close(); err != nil {
+		return err
+	}
+
+	file, err := openFile(r.filename, r.fileMode)
+	if err != nil {
+		return fmt.Errorf("can't reopen logfile: %s", err)
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("can't stat logfile: %s", err)
+	}
+
+	r.file = file
+	r.fileSizeByte = fi.Size()
+	return nil
+}
+
+func (r *rotator) sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Sync()
+}
+
+func (r *rotator) shutdown() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+
+	r.closed = true
+	return r.close()
+}
+
 func (r *rotator) close() error {
 	if r.file == nil {
 		return nil
@@ -223,10 +425,11 @@ func (r *rotator) rotateWrite(content []byte) (n int, err error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	writeLen := int64(len(content))
+	if r.closed {
+		return 0, os.ErrClosed
+	}
 
-	if (r.isDuration && time.Now().After(r.nextTime)) ||
-		(r.isFileSize && r.fileSizeByte+writeLen >= r.maxSizeByte) {
+	if r.shouldRotate(int64(len(content))) {
 		if err = r.close(); err != nil {
 			return 0, err
 		}
@@ -242,3 +445,49 @@ func (r *rotator) rotateWrite(content []byte) (n int, err error) {
 	r.fileSizeByte += int64(n)
 	return n, err
 }
+
+// shouldRotate reports whether a write of writeLen bytes goes to a new file.
+func (r *rotator) shouldRotate(writeLen int64) bool {
+	return (r.isDuration && !r.nextTime.IsZero() && !r.now().Before(r.nextTime)) ||
+		(r.isFileSize && r.fileSizeByte+writeLen >= r.maxSizeByte)
+}
+
+// writeRecords writes the records of buf, lens holds their lengths, rotating
+// between records the way rotateWrite does, with one write per file.
+func (r *rotator) writeRecords(buf []byte, lens []int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return os.ErrClosed
+	}
+
+	var start, end int
+	flush := func() error {
+		if start == end {
+			return nil
+		}
+		n, err := r.file.Write(buf[start:end])
+		r.fileSizeByte += int64(n)
+		start = end
+		return err
+	}
+
+	for _, l := range lens {
+		if r.shouldRotate(int64(end - start + l)) {
+			if err := flush(); err != nil {
+				return err
+			}
+			if err := r.close(); err != nil {
+				return err
+			}
+		}
+		if r.file == nil {
+			if err := r.openNewFile(); err != nil {
+				return err
+			}
+		}
+		end += l
+	}
+	return flush()
+}
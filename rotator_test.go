@@ -0,0 +1,224 @@
+package loggeradapter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotatorPeriodBounds(t *testing.T) {
+	at := func(y int, m time.Month, d, h, min, s int) time.Time {
+		return time.Date(y, m, d, h, min, s, 0, time.UTC)
+	}
+
+	cases := []struct {
+		rotation    string
+		now         time.Time
+		start, next time.Time
+	}{
+		{"daily", at(2024, 1, 1, 14, 37, 0), at(2024, 1, 1, 0, 0, 0), at(2024, 1, 2, 0, 0, 0)},
+		{"hourly", at(2024, 1, 1, 14, 37, 0), at(2024, 1, 1, 14, 0, 0), at(2024, 1, 1, 15, 0, 0)},
+		{"monthly", at(2024, 2, 15, 14, 37, 0), at(2024, 2, 1, 0, 0, 0), at(2024, 3, 1, 0, 0, 0)},
+		{"annually", at(2024, 2, 15, 14, 37, 0), at(2024, 1, 1, 0, 0, 0), at(2025, 1, 1, 0, 0, 0)},
+		// 2024-01-03 is a Wednesday, ISO weeks start on Monday
+		{"weekly", at(2024, 1, 3, 14, 37, 0), at(2024, 1, 1, 0, 0, 0), at(2024, 1, 8, 0, 0, 0)},
+		{"15m", at(2024, 1, 1, 14, 37, 12), at(2024, 1, 1, 14, 30, 0), at(2024, 1, 1, 14, 45, 0)},
+		{"6h", at(2024, 1, 1, 14, 37, 0), at(2024, 1, 1, 12, 0, 0), at(2024, 1, 1, 18, 0, 0)},
+		{"7m", at(2024, 1, 1, 14, 57, 0), at(2024, 1, 1, 14, 56, 0), at(2024, 1, 1, 15, 0, 0)},
+		{"10s", at(2024, 1, 1, 14, 37, 12), at(2024, 1, 1, 14, 37, 10), at(2024, 1, 1, 14, 37, 20)},
+		{"2d", at(2024, 1, 31, 14, 37, 0), at(2024, 1, 31, 0, 0, 0), at(2024, 2, 1, 0, 0, 0)},
+		{"3M", at(2024, 5, 15, 0, 0, 0), at(2024, 4, 1, 0, 0, 0), at(2024, 7, 1, 0, 0, 0)},
+		{"2w", at(2024, 1, 10, 0, 0, 0), at(2024, 1, 1, 0, 0, 0), at(2024, 1, 15, 0, 0, 0)},
+	}
+
+	for _, c := range cases {
+		r, err := newRotator(Config{Rotation: c.rotation}, newOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start, next := r.periodBounds(c.now)
+		if !start.Equal(c.start) || !next.Equal(c.next) {
+			t.Errorf("%s at %v: got [%v, %v), want [%v, %v)", c.rotation, c.now, start, next, c.start, c.next)
+		}
+	}
+}
+
+func TestRotatorWeeksAcrossYears(t *testing.T) {
+	for _, rotation := range []string{"2w", "3w"} {
+		r, err := newRotator(Config{Rotation: rotation}, newOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 2026 has 53 ISO weeks, so week 1 of 2027 would start a period again
+		var prevStart, prevNext time.Time
+		for day := time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC); day.Before(time.Date(2027, 3, 1, 0, 0, 0, 0, time.UTC)); day = day.AddDate(0, 0, 1) {
+			start, next := r.periodBounds(day)
+			if day.Before(start) || !day.Before(next) || start.Weekday() != time.Monday ||
+				next.Sub(start) != time.Duration(r.v)*7*24*time.Hour {
+				t.Fatalf("%s at %v: got [%v, %v)", rotation, day, start, next)
+			}
+			if !prevStart.IsZero() && !start.Equal(prevStart) && !start.Equal(prevNext) {
+				t.Fatalf("%s at %v: [%v, %v) doesn't follow [%v, %v)", rotation, day, start, next, prevStart, prevNext)
+			}
+			prevStart, prevNext = start, next
+		}
+	}
+}
+
+func TestRotatorNamesBackupAfterPeriod(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 1, 14, 37, 0, 0, time.Local)
+
+	w, err := NewWriter(Config{Filename: filepath.Join(dir, "app.log"), Rotation: "daily"},
+		WithClock(ClockFunc(func() time.Time { return now })))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if _, err = w.Write([]byte("monday\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	now = time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local)
+	if _, err = w.Write([]byte("tuesday\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "app-2024-01-01.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "monday\n" {
+		t.Errorf("unexpected backup content %q", content)
+	}
+}
+
+func TestRotatorSizeWithinPeriod(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 1, 14, 37, 0, 0, time.Local)
+
+	w, err := NewWriter(Config{Filename: filepath.Join(dir, "log.log"), Rotation: "daily,10b"},
+		WithClock(ClockFunc(func() time.Time { return now })))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err = w.Write([]byte("0123456789")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"log-2024-01-01.log", "log-2024-01-01.1.log", "log-2024-01-01.2.log"} {
+		if _, err = os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected backup %s: %v", name, err)
+		}
+	}
+}
+
+func TestRotatorCronSchedule(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 1, 1, 30, 0, 0, time.Local)
+
+	w, err := NewWriter(Config{Filename: filepath.Join(dir, "log.log"), Rotation: "0 2 * * *"},
+		WithClock(ClockFunc(func() time.Time { return now })))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if _, err = w.Write([]byte("before\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	now = time.Date(2024, 1, 1, 2, 0, 0, 0, time.Local)
+	if _, err = w.Write([]byte("after\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "log-2024-01-01T01-30.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "before\n" {
+		t.Errorf("unexpected backup content %q", content)
+	}
+}
+
+func TestRotatorLocation(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// 2024-11-03 01:00 EDT is followed by 01:00 EST
+	hourly, err := newRotator(Config{Rotation: "hourly"}, options{clock: systemClock{}, location: newYork})
+	if err != nil {
+		t.Fatal(err)
+	}
+	edt := time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC).In(newYork)
+	start, next := hourly.periodBounds(edt)
+	if start.Format("15:04 MST") != "01:00 EDT" || next.Format("15:04 MST") != "01:00 EST" {
+		t.Errorf("fall back: got [%v, %v)", start, next)
+	}
+	if next.Sub(start) != time.Hour {
+		t.Errorf("fall back: expected an hour long period, got %v", next.Sub(start))
+	}
+
+	// 2024-03-10 is 23 hours long in New York
+	daily, err := newRotator(Config{Rotation: "daily"}, options{clock: systemClock{}, location: newYork})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, next = daily.periodBounds(time.Date(2024, 3, 10, 12, 0, 0, 0, newYork))
+	if next.Sub(start) != 23*time.Hour || next.Format("2006-01-02 15:04") != "2024-03-11 00:00" {
+		t.Errorf("spring forward: got [%v, %v)", start, next)
+	}
+
+	// multi-hour periods stay on the wall clock, the one spanning the
+	// change is an hour shorter
+	sixHourly, err := newRotator(Config{Rotation: "6h"}, options{clock: systemClock{}, location: newYork})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		t           time.Time
+		start, next string
+	}{
+		{time.Date(2024, 3, 10, 1, 30, 0, 0, newYork), "00:00 EST", "06:00 EDT"},
+		{time.Date(2024, 3, 10, 7, 0, 0, 0, newYork), "06:00 EDT", "12:00 EDT"},
+		{time.Date(2024, 3, 10, 13, 0, 0, 0, newYork), "12:00 EDT", "18:00 EDT"},
+		{time.Date(2024, 3, 10, 23, 59, 0, 0, newYork), "18:00 EDT", "00:00 EDT"},
+		{time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC).In(newYork), "00:00 EDT", "06:00 EST"},
+	} {
+		start, next = sixHourly.periodBounds(tc.t)
+		if start.Format("15:04 MST") != tc.start || next.Format("15:04 MST") != tc.next {
+			t.Errorf("6h at %v: got [%v, %v)", tc.t, start, next)
+		}
+	}
+}
+
+func TestWriterUTC(t *testing.T) {
+	dir := t.TempDir()
+	// 2024-01-01 23:30 in UTC-5 is already 2024-01-02 in UTC
+	now := time.Date(2024, 1, 1, 23, 30, 0, 0, time.FixedZone("UTC-5", -5*60*60))
+
+	w, err := NewWriter(Config{Filename: filepath.Join(dir, "log.log"), Rotation: "daily", UTC: true},
+		WithClock(ClockFunc(func() time.Time { return now })))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if err = w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "log-2024-01-02.log")); err != nil {
+		t.Errorf("expected backup named after the UTC day: %v", err)
+	}
+}
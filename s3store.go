@@ -0,0 +1,504 @@
+package loggeradapter
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3MinPartSize     = 5 * 1024 * 1024
+	s3DefaultPartSize = 16 * 1024 * 1024
+	s3MaxParts        = 10000
+	s3Service         = "s3"
+	s3Algorithm       = "AWS4-HMAC-SHA256"
+	s3TimeFormat      = "20060102T150405Z"
+	s3DateFormat      = "20060102"
+)
+
+// S3Config configures an S3Store.
+type S3Config struct {
+	// Endpoint is the URL of the S3-compatible service, such as
+	// https://s3.eu-west-1.amazonaws.com or http://localhost:9000.
+	Endpoint string
+	// Region is the region requests are signed for, it defaults to us-east-1.
+	Region string
+	Bucket string
+	// Prefix is prepended to the names of archives, such as logs/app/.
+	Prefix string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is the token of temporary credentials.
+	SessionToken string
+
+	// VirtualHostStyle addresses the bucket as a subdomain of the endpoint
+	// instead of the first element of the path.
+	VirtualHostStyle bool
+	// PartSize is the size of the parts of multipart uploads, archives up to
+	// this size are uploaded in a single request. It defaults to 16MB and
+	// can't be less than 5MB.
+	PartSize int64
+
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// S3Error is an error response of an S3-compatible service.
+type S3Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Key        string
+}
+
+// Is makes an error for a missing object match fs.ErrNotExist.
+func (e *S3Error) Is(target error) bool {
+	return target == fs.ErrNotExist && e.StatusCode == http.StatusNotFound
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3 request for %q failed with status %d", e.Key, e.StatusCode)
+	}
+	return fmt.Sprintf("s3 request for %q failed with status %d: %s: %s", e.Key, e.StatusCode, e.Code, e.Message)
+}
+
+// S3Store stores archives in a bucket of an S3-compatible service, requests
+// are signed with AWS Signature Version 4.
+type S3Store struct {
+	endpoint *url.URL
+	region   string
+	bucket   string
+	prefix   string
+	keyID    string
+	secret   string
+	token    string
+	virtual  bool
+	partSize int64
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewS3Store returns a store uploading archives to the bucket.
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, &ConfigError{Field: "Endpoint", Value: cfg.Endpoint, Reason: "expected a URL such as https://s3.amazonaws.com"}
+	}
+	if cfg.Bucket == "" {
+		return nil, &ConfigError{Field: "Bucket", Value: cfg.Bucket, Reason: "can't be empty"}
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, &ConfigError{Field: "AccessKeyID", Value: cfg.AccessKeyID, Reason: "credentials are required"}
+	}
+
+	partSize := cfg.PartSize
+	if partSize == 0 {
+		partSize = s3DefaultPartSize
+	}
+	if partSize < s3MinPartSize {
+		return nil, &ConfigError{Field: "PartSize", Value: strconv.FormatInt(cfg.PartSize, 10), Reason: "must be at least 5MB"}
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	prefix := strings.TrimPrefix(cfg.Prefix, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/")
+	return &S3Store{
+		endpoint: endpoint,
+		region:   region,
+		bucket:   cfg.Bucket,
+		prefix:   prefix,
+		keyID:    cfg.AccessKeyID,
+		secret:   cfg.SecretAccessKey,
+		token:    cfg.SessionToken,
+		virtual:  cfg.VirtualHostStyle,
+		partSize: partSize,
+		client:   client,
+		now:      time.Now,
+	}, nil
+}
+
+// Put uploads the archive in one request, or in parts when it's larger than
+// the part size. A failed multipart upload is aborted.
+func (s *S3Store) Put(ctx context.Context, name string, r io.Reader) error {
+	// the buffer grows with the content, so sidecars stay small
+	var first bytes.Buffer
+	n, err := first.ReadFrom(io.LimitReader(r, s.partSize+1))
+	if err != nil {
+		return err
+	}
+	if n <= s.partSize {
+		_, err = s.do(ctx, http.MethodPut, name, nil, first.Bytes())
+		return err
+	}
+
+	// the byte read past the first part starts the second one
+	buf := first.Bytes()
+	r = io.MultiReader(bytes.NewReader([]byte{buf[s.partSize]}), r)
+
+	uploadID, err := s.createMultipartUpload(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err = s.uploadParts(ctx, name, uploadID, buf[:s.partSize], r); err != nil {
+		// the parts of an aborted upload are discarded by the service
+		abortCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		_, _ = s.do(abortCtx, http.MethodDelete, name, url.Values{"uploadId": {uploadID}}, nil)
+		return err
+	}
+	return nil
+}
+
+type s3CompletedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+func (s *S3Store) createMultipartUpload(ctx context.Context, name string) (string, error) {
+	resp, err := s.do(ctx, http.MethodPost, name, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err = xml.Unmarshal(resp, &result); err != nil || result.UploadID == "" {
+		return "", fmt.Errorf("invalid response to the multipart upload of %s: %v", name, err)
+	}
+	return result.UploadID, nil
+}
+
+// uploadParts uploads buf as the first part and reuses it for the rest of r.
+func (s *S3Store) uploadParts(ctx context.Context, name, uploadID string, buf []byte, r io.Reader) error {
+	var parts []s3CompletedPart
+
+	part := buf
+	for number := 1; len(part) > 0; number++ {
+		if number > s3MaxParts {
+			return fmt.Errorf("archive %s has more than %d parts", name, s3MaxParts)
+		}
+
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		header := make(http.Header)
+		if _, err := s.doHeader(ctx, http.MethodPut, name, query, part, header); err != nil {
+			return err
+		}
+		parts = append(parts, s3CompletedPart{PartNumber: number, ETag: header.Get("ETag")})
+
+		n, err := io.ReadFull(r, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+		part = buf[:n]
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodPost, name, url.Values{"uploadId": {uploadID}}, body)
+	if err != nil {
+		return err
+	}
+	// the service may report a failure with a status of 200
+	return s3ResponseError(name, http.StatusOK, resp)
+}
+
+// List returns the objects below the prefix, their names don't include it.
+func (s *S3Store) List(ctx context.Context) ([]ArchiveInfo, error) {
+	var infos []ArchiveInfo
+
+	query := url.Values{"list-type": {"2"}, "prefix": {s.prefix}}
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			IsTruncated           bool
+			NextContinuationToken string
+			Contents              []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+		}
+		if err = xml.Unmarshal(resp, &result); err != nil {
+			return nil, fmt.Errorf("invalid listing of bucket %s: %w", s.bucket, err)
+		}
+
+		for _, c := range result.Contents {
+			name := strings.TrimPrefix(c.Key, s.prefix)
+			if name == "" || strings.HasSuffix(name, "/") {
+				continue
+			}
+			infos = append(infos, ArchiveInfo{Name: name, Size: c.Size, ModTime: c.LastModified})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return infos, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (s *S3Store) Delete(ctx context.Context, name string) error {
+	_, err := s.do(ctx, http.MethodDelete, name, nil, nil)
+	var serr *S3Error
+	if errors.As(err, &serr) && serr.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// Open downloads the archive, a missing archive is an error matching fs.ErrNotExist.
+func (s *S3Store) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		_ = resp.Body.Close()
+		return nil, s3StatusError(name, resp.StatusCode, body)
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Store) do(ctx context.Context, method, name string, query url.Values, body []byte) ([]byte, error) {
+	return s.doHeader(ctx, method, name, query, body, nil)
+}
+
+// doHeader sends a request and returns the response body, the response
+// headers are copied to respHeader when it's not nil.
+func (s *S3Store) doHeader(ctx context.Context, method, name string, query url.Values, body []byte, respHeader http.Header) ([]byte, error) {
+	req, err := s.newRequest(ctx, method, name, query, body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, s3StatusError(name, resp.StatusCode, content)
+	}
+	for k, v := range resp.Header {
+		if respHeader != nil {
+			respHeader[k] = v
+		}
+	}
+	return content, nil
+}
+
+func s3StatusError(name string, status int, body []byte) error {
+	if err := s3ResponseError(name, status, body); err != nil {
+		return err
+	}
+	return &S3Error{StatusCode: status, Key: name}
+}
+
+// s3ResponseError returns the error of an <Error> response body.
+func s3ResponseError(name string, status int, body []byte) error {
+	var e struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	if xml.Unmarshal(body, &e) != nil || e.XMLName.Local != "Error" {
+		return nil
+	}
+	return &S3Error{StatusCode: status, Code: e.Code, Message: e.Message, Key: name}
+}
+
+func (s *S3Store) newRequest(ctx context.Context, method, name string, query url.Values, body []byte) (*http.Request, error) {
+	if name != "" {
+		if err := checkArchiveName(name); err != nil {
+			return nil, err
+		}
+	}
+
+	u := *s.endpoint
+	path := u.Path
+	if s.virtual {
+		u.Host = s.bucket + "." + u.Host
+	} else {
+		path += "/" + s.bucket
+	}
+	if name != "" {
+		path += "/" + s.prefix + name
+	} else if !s.virtual {
+		path += "/"
+	}
+	if path == "" {
+		path = "/"
+	}
+	u.Path = path
+	u.RawPath = s3Escape(path, false)
+	u.RawQuery = s3Query(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		req.Body = http.NoBody
+		req.ContentLength = 0
+	}
+
+	sum := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	req.Header.Set("X-Amz-Date", s.now().UTC().Format(s3TimeFormat))
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+	signS3Request(req, s.keyID, s.secret, s.region)
+	return req, nil
+}
+
+// signS3Request adds the Signature Version 4 Authorization header, the host
+// and every header already set are signed.
+func signS3Request(req *http.Request, keyID, secret, region string) {
+	amzDate := req.Header.Get("X-Amz-Date")
+	t, _ := time.Parse(s3TimeFormat, amzDate)
+	scope := t.Format(s3DateFormat) + "/" + region + "/" + s3Service + "/aws4_request"
+
+	signature, signedHeaders := s3Signature(req, secret, region, t)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, keyID, scope, signedHeaders, signature))
+}
+
+func s3Signature(req *http.Request, secret, region string, t time.Time) (string, string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	headers := map[string]string{"host": host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if k == "authorization" {
+			continue
+		}
+		headers[k] = strings.Join(v, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonical strings.Builder
+	canonical.WriteString(req.Method + "\n")
+	canonical.WriteString(req.URL.EscapedPath() + "\n")
+	canonical.WriteString(s3Query(req.URL.Query()) + "\n")
+	for _, k := range names {
+		canonical.WriteString(k + ":" + strings.TrimSpace(headers[k]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonical.WriteString("\n" + signedHeaders + "\n")
+	canonical.WriteString(req.Header.Get("X-Amz-Content-Sha256"))
+
+	date := t.Format(s3DateFormat)
+	scope := date + "/" + region + "/" + s3Service + "/aws4_request"
+	hash := sha256.Sum256([]byte(canonical.String()))
+	stringToSign := s3Algorithm + "\n" + t.Format(s3TimeFormat) + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := s3SigningKey(secret, date, region, s3Service)
+	return hex.EncodeToString(hmacSHA256(key, stringToSign)), signedHeaders
+}
+
+func s3SigningKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Query encodes the query sorted by key as required by the signature.
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape percent-encodes all but the unreserved characters, slashes are
+// kept in paths.
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,749 @@
+package loggeradapter
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sftpVersion = 3
+
+	sftpInit     = 1
+	sftpVersionP = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpWrite    = 6
+	sftpOpendir  = 11
+	sftpReaddir  = 12
+	sftpRemove   = 13
+	sftpMkdir    = 14
+	sftpRmdir    = 15
+	sftpStat     = 17
+	sftpRename   = 18
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+	sftpName     = 104
+	sftpAttrs    = 105
+
+	sftpFlagRead  = 0x01
+	sftpFlagWrite = 0x02
+	sftpFlagCreat = 0x08
+	sftpFlagTrunc = 0x10
+
+	sftpAttrSize        = 0x01
+	sftpAttrUIDGID      = 0x02
+	sftpAttrPermissions = 0x04
+	sftpAttrACModTime   = 0x08
+	sftpAttrExtended    = 0x80000000
+
+	sftpStatusOK         = 0
+	sftpStatusEOF        = 1
+	sftpStatusNoSuchFile = 2
+
+	sftpChunkSize     = 32 * 1024
+	sftpMaxPacketSize = 256 * 1024
+)
+
+// SFTPConfig configures an SFTPStore.
+type SFTPConfig struct {
+	// Host is the SSH server, it may be a host alias of the ssh configuration.
+	Host string
+	Port int
+	User string
+	// IdentityFile is the private key to authenticate with, the keys of the
+	// ssh agent and configuration are used otherwise.
+	IdentityFile string
+	// Dir is the remote directory archives are written to, relative to the
+	// home directory unless absolute.
+	Dir string
+
+	// Command overrides the ssh command line started for every operation,
+	// its standard input and output carry the SFTP protocol. It defaults to
+	// ssh -o BatchMode=yes [-p Port] [-l User] [-i IdentityFile] -s -- Host sftp.
+	Command []string
+	// Dial overrides Command and returns a connection speaking the SFTP
+	// protocol, such as the sftp subsystem of a golang.org/x/crypto/ssh
+	// session.
+	Dial func(ctx context.Context) (io.ReadWriteCloser, error)
+}
+
+// SFTPStore stores archives in a directory of an SFTP server. It speaks
+// version 3 of the SFTP protocol over the sftp subsystem of the ssh command,
+// so host keys and authentication follow the ssh configuration.
+type SFTPStore struct {
+	dir  string
+	dial func(ctx context.Context) (io.ReadWriteCloser, error)
+}
+
+// NewSFTPStore returns a store writing archives to the directory of the server.
+func NewSFTPStore(cfg SFTPConfig) (*SFTPStore, error) {
+	dir := strings.TrimSuffix(cfg.Dir, "/")
+	if dir == "" {
+		dir = "."
+	}
+
+	dial := cfg.Dial
+	if dial == nil {
+		args := cfg.Command
+		if len(args) == 0 {
+			if cfg.Host == "" {
+				return nil, &ConfigError{Field: "Host", Value: cfg.Host, Reason: "can't be empty"}
+			}
+			if strings.HasPrefix(cfg.Host, "-") {
+				return nil, &ConfigError{Field: "Host", Value: cfg.Host, Reason: "can't start with -"}
+			}
+			args = []string{"ssh", "-o", "BatchMode=yes"}
+			if cfg.Port != 0 {
+				args = append(args, "-p", strconv.Itoa(cfg.Port))
+			}
+			if cfg.User != "" {
+				args = append(args, "-l", cfg.User)
+			}
+			if cfg.IdentityFile != "" {
+				args = append(args, "-i", cfg.IdentityFile)
+			}
+			args = append(args, "-s", "--", cfg.Host, "sftp")
+		}
+		dial = func(context.Context) (io.ReadWriteCloser, error) {
+			return startSFTPCommand(args)
+		}
+	}
+
+	return &SFTPStore{dir: dir, dial: dial}, nil
+}
+
+// Put writes the archive under a temporary name and renames it into place.
+func (s *SFTPStore) Put(ctx context.Context, name string, r io.Reader) error {
+	if err := checkArchiveName(name); err != nil {
+		return err
+	}
+
+	c, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	target := s.dir + "/" + name
+	if err = c.mkdirAll(path.Dir(target)); err != nil {
+		return err
+	}
+
+	tmp := target + archiveTempSuffix
+	if err = c.upload(tmp, r); err != nil {
+		_ = c.remove(tmp)
+		return err
+	}
+
+	// renaming over an existing file fails in version 3 of the protocol
+	if err = c.remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return c.rename(tmp, target)
+}
+
+func (s *SFTPStore) List(ctx context.Context) ([]ArchiveInfo, error) {
+	c, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var infos []ArchiveInfo
+	err = c.walk(s.dir, "", func(rel string, attrs sftpAttributes) {
+		infos = append(infos, ArchiveInfo{Name: rel, Size: attrs.size, ModTime: attrs.modTime})
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return infos, err
+}
+
+// Delete removes the file and the directories it leaves empty.
+func (s *SFTPStore) Delete(ctx context.Context, name string) error {
+	if err := checkArchiveName(name); err != nil {
+		return err
+	}
+
+	c, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err = c.remove(s.dir + "/" + name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if c.rmdir(s.dir+"/"+dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Open downloads the archive, the connection stays open until the returned
+// reader is closed.
+func (s *SFTPStore) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := checkArchiveName(name); err != nil {
+		return nil, err
+	}
+
+	c, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	handle, err := c.open(s.dir+"/"+name, sftpFlagRead)
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	return &sftpFileReader{c: c, handle: handle}, nil
+}
+
+func (s *SFTPStore) connect(ctx context.Context) (*sftpClient, error) {
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't connect to the sftp server: %w", err)
+	}
+
+	c := &sftpClient{conn: conn, r: bufio.NewReader(conn)}
+
+	// the connection is closed when the context ends before an operation does
+	stop := make(chan struct{})
+	c.stop = func() { close(stop) }
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-stop:
+		}
+	}()
+
+	if err = c.init(); err != nil {
+		// a failing ssh command, such as on a rejected key, tells why on stderr
+		if closeErr := c.Close(); closeErr != nil {
+			return nil, fmt.Errorf("sftp handshake failed: %v, %w", err, closeErr)
+		}
+		return nil, fmt.Errorf("sftp handshake failed: %w", err)
+	}
+	return c, nil
+}
+
+// sftpStderrLimit is the most of the ssh command's stderr kept for errors.
+const sftpStderrLimit = 4096
+
+// sftpCommand runs the ssh command, its standard input and output are the connection.
+type sftpCommand struct {
+	cmd    *exec.Cmd
+	stderr limitedBuffer
+	io.Reader
+	io.WriteCloser
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// limitedBuffer keeps the first limit bytes written to it.
+type limitedBuffer struct {
+	mu    sync.Mutex
+	buf   []byte
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if n := b.limit - len(b.buf); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		b.buf = append(b.buf, p[:n]...)
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.TrimSpace(string(b.buf))
+}
+
+func startSFTPCommand(args []string) (io.ReadWriteCloser, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	c := &sftpCommand{cmd: cmd, stderr: limitedBuffer{limit: sftpStderrLimit}, Reader: stdout, WriteCloser: stdin}
+	cmd.Stderr = &c.stderr
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close ends the command and returns how it failed along with its stderr, it
+// may be called again when a context ends.
+func (c *sftpCommand) Close() error {
+	c.closeOnce.Do(func() {
+		_ = c.WriteCloser.Close()
+
+		done := make(chan error, 1)
+		go func() { done <- c.cmd.Wait() }()
+		var err error
+		select {
+		case err = <-done:
+		case <-time.After(5 * time.Second):
+			_ = c.cmd.Process.Kill()
+			err = <-done
+		}
+		if err != nil {
+			if stderr := c.stderr.String(); stderr != "" {
+				err = fmt.Errorf("%s failed: %w: %s", c.cmd.Args[0], err, stderr)
+			} else {
+				err = fmt.Errorf("%s failed: %w", c.cmd.Args[0], err)
+			}
+		}
+		c.closeErr = err
+	})
+	return c.closeErr
+}
+
+// SFTPError is an error status returned by an SFTP server.
+type SFTPError struct {
+	Op      string
+	Path    string
+	Code    uint32
+	Message string
+}
+
+// Is makes an error for a missing file match fs.ErrNotExist.
+func (e *SFTPError) Is(target error) bool {
+	return target == fs.ErrNotExist && e.Code == sftpStatusNoSuchFile
+}
+
+func (e *SFTPError) Error() string {
+	return fmt.Sprintf("sftp %s %s failed with status %d: %s", e.Op, e.Path, e.Code, e.Message)
+}
+
+// sftpClient sends one request at a time, which is plenty for a few archives
+// per pass.
+type sftpClient struct {
+	mu   sync.Mutex
+	conn io.ReadWriteCloser
+	r    *bufio.Reader
+	id   uint32
+	stop func()
+}
+
+func (c *sftpClient) Close() error {
+	c.stop()
+	return c.conn.Close()
+}
+
+func (c *sftpClient) init() error {
+	packet := appendUint32(nil, sftpVersion)
+	if err := c.send(sftpInit, packet); err != nil {
+		return err
+	}
+
+	typ, payload, err := c.receive()
+	if err != nil {
+		return err
+	}
+	if typ != sftpVersionP || len(payload) < 4 {
+		return errors.New("invalid sftp version response")
+	}
+	if v := binary.BigEndian.Uint32(payload); v < sftpVersion {
+		return fmt.Errorf("unsupported sftp version %d", v)
+	}
+	return nil
+}
+
+func (c *sftpClient) send(typ byte, payload []byte) error {
+	packet := appendUint32(make([]byte, 0, 5+len(payload)), uint32(1+len(payload)))
+	packet = append(packet, typ)
+	packet = append(packet, payload...)
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+func (c *sftpClient) receive() (byte, []byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(c.r, size[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n == 0 || n > sftpMaxPacketSize {
+		return 0, nil, fmt.Errorf("invalid sftp packet length %d", n)
+	}
+
+	packet := make([]byte, n)
+	if _, err := io.ReadFull(c.r, packet); err != nil {
+		return 0, nil, err
+	}
+	return packet[0], packet[1:], nil
+}
+
+// request sends a request and returns the response without its ID.
+func (c *sftpClient) request(typ byte, payload []byte) (byte, []byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.id++
+	if err := c.send(typ, append(appendUint32(nil, c.id), payload...)); err != nil {
+		return 0, nil, err
+	}
+
+	rtyp, resp, err := c.receive()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(resp) < 4 || binary.BigEndian.Uint32(resp) != c.id {
+		return 0, nil, errors.New("unexpected sftp response")
+	}
+	return rtyp, resp[4:], nil
+}
+
+// status returns the error of a status response.
+func (c *sftpClient) status(op, p string, typ byte, resp []byte) error {
+	if typ != sftpStatus {
+		return fmt.Errorf("unexpected sftp response %d to %s %s", typ, op, p)
+	}
+
+	d := sftpDecoder{b: resp}
+	code := d.uint32()
+	msg := d.string()
+	if d.err != nil {
+		return d.err
+	}
+	if code == sftpStatusOK {
+		return nil
+	}
+	return &SFTPError{Op: op, Path: p, Code: code, Message: msg}
+}
+
+func (c *sftpClient) simple(op string, typ byte, p string, extra []byte) error {
+	rtyp, resp, err := c.request(typ, append(appendSFTPString(nil, p), extra...))
+	if err != nil {
+		return err
+	}
+	return c.status(op, p, rtyp, resp)
+}
+
+func (c *sftpClient) remove(p string) error {
+	return c.simple("remove", sftpRemove, p, nil)
+}
+
+func (c *sftpClient) rmdir(p string) error {
+	return c.simple("rmdir", sftpRmdir, p, nil)
+}
+
+func (c *sftpClient) rename(from, to string) error {
+	return c.simple("rename", sftpRename, from, appendSFTPString(nil, to))
+}
+
+func (c *sftpClient) mkdirAll(dir string) error {
+	if dir == "." || dir == "/" {
+		return nil
+	}
+	if attrs, err := c.stat(dir); err == nil {
+		if !attrs.dir {
+			return fmt.Errorf("sftp path %s isn't a directory", dir)
+		}
+		return nil
+	}
+	if err := c.mkdirAll(path.Dir(dir)); err != nil {
+		return err
+	}
+	return c.simple("mkdir", sftpMkdir, dir, appendUint32(nil, 0))
+}
+
+func (c *sftpClient) stat(p string) (sftpAttributes, error) {
+	typ, resp, err := c.request(sftpStat, appendSFTPString(nil, p))
+	if err != nil {
+		return sftpAttributes{}, err
+	}
+	if typ != sftpAttrs {
+		return sftpAttributes{}, c.status("stat", p, typ, resp)
+	}
+
+	d := sftpDecoder{b: resp}
+	attrs := d.attrs()
+	return attrs, d.err
+}
+
+func (c *sftpClient) open(p string, flags uint32) (string, error) {
+	payload := appendUint32(appendSFTPString(nil, p), flags)
+	return c.handle("open", sftpOpen, p, appendUint32(payload, 0))
+}
+
+func (c *sftpClient) handle(op string, typ byte, p string, payload []byte) (string, error) {
+	rtyp, resp, err := c.request(typ, payload)
+	if err != nil {
+		return "", err
+	}
+	if rtyp != sftpHandle {
+		if err = c.status(op, p, rtyp, resp); err == nil {
+			err = fmt.Errorf("sftp %s %s returned no handle", op, p)
+		}
+		return "", err
+	}
+
+	d := sftpDecoder{b: resp}
+	handle := d.string()
+	return handle, d.err
+}
+
+func (c *sftpClient) close(handle string) error {
+	return c.simple("close", sftpClose, handle, nil)
+}
+
+func (c *sftpClient) upload(p string, r io.Reader) error {
+	handle, err := c.open(p, sftpFlagWrite|sftpFlagCreat|sftpFlagTrunc)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, sftpChunkSize)
+	var offset uint64
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			payload := appendSFTPString(nil, handle)
+			payload = appendUint64(payload, offset)
+			payload = appendSFTPString(payload, string(buf[:n]))
+			typ, resp, err := c.request(sftpWrite, payload)
+			if err == nil {
+				err = c.status("write", p, typ, resp)
+			}
+			if err != nil {
+				_ = c.close(handle)
+				return err
+			}
+			offset += uint64(n)
+		}
+		if errors.Is(readErr, io.EOF) || errors.Is(readErr, io.ErrUnexpectedEOF) {
+			break
+		}
+		if readErr != nil {
+			_ = c.close(handle)
+			return readErr
+		}
+	}
+	return c.close(handle)
+}
+
+// walk calls fn with every regular file below dir, rel is its slash path
+// relative to the root of the walk.
+func (c *sftpClient) walk(dir, rel string, fn func(rel string, attrs sftpAttributes)) error {
+	handle, err := c.handle("opendir", sftpOpendir, dir, appendSFTPString(nil, dir))
+	if err != nil {
+		return err
+	}
+
+	var subdirs []string
+	for {
+		typ, resp, err := c.request(sftpReaddir, appendSFTPString(nil, handle))
+		if err != nil {
+			return err
+		}
+		if typ != sftpName {
+			err = c.status("readdir", dir, typ, resp)
+			var serr *SFTPError
+			if errors.As(err, &serr) && serr.Code == sftpStatusEOF {
+				break
+			}
+			if err == nil {
+				err = fmt.Errorf("unexpected sftp response to readdir %s", dir)
+			}
+			_ = c.close(handle)
+			return err
+		}
+
+		d := sftpDecoder{b: resp}
+		for n := d.uint32(); n > 0 && d.err == nil; n-- {
+			name := d.string()
+			_ = d.string() // long name
+			attrs := d.attrs()
+			if d.err != nil || name == "." || name == ".." {
+				continue
+			}
+
+			child := name
+			if rel != "" {
+				child = rel + "/" + name
+			}
+			switch {
+			case attrs.dir:
+				subdirs = append(subdirs, child)
+			case attrs.regular:
+				fn(child, attrs)
+			}
+		}
+		if d.err != nil {
+			_ = c.close(handle)
+			return d.err
+		}
+	}
+	if err = c.close(handle); err != nil {
+		return err
+	}
+
+	for _, sub := range subdirs {
+		if err = c.walk(dir+"/"+path.Base(sub), sub, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type sftpFileReader struct {
+	c      *sftpClient
+	handle string
+	offset uint64
+	eof    bool
+}
+
+func (r *sftpFileReader) Read(p []byte) (int, error) {
+	if r.eof {
+		return 0, io.EOF
+	}
+	if len(p) > sftpChunkSize {
+		p = p[:sftpChunkSize]
+	}
+
+	payload := appendSFTPString(nil, r.handle)
+	payload = appendUint64(payload, r.offset)
+	payload = appendUint32(payload, uint32(len(p)))
+	typ, resp, err := r.c.request(sftpRead, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	if typ != sftpData {
+		err = r.c.status("read", r.handle, typ, resp)
+		var serr *SFTPError
+		if errors.As(err, &serr) && serr.Code == sftpStatusEOF {
+			r.eof = true
+			return 0, io.EOF
+		}
+		if err == nil {
+			err = errors.New("unexpected sftp response to read")
+		}
+		return 0, err
+	}
+
+	d := sftpDecoder{b: resp}
+	data := d.string()
+	if d.err != nil {
+		return 0, d.err
+	}
+	n := copy(p, data)
+	r.offset += uint64(n)
+	return n, nil
+}
+
+func (r *sftpFileReader) Close() error {
+	err := r.c.close(r.handle)
+	if closeErr := r.c.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+type sftpAttributes struct {
+	size    int64
+	mode    uint32
+	modTime time.Time
+	dir     bool
+	regular bool
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
+
+func appendSFTPString(b []byte, s string) []byte {
+	return append(appendUint32(b, uint32(len(s))), s...)
+}
+
+// sftpDecoder reads the fields of a packet, the first error sticks.
+type sftpDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *sftpDecoder) uint32() uint32 {
+	if d.err != nil || len(d.b) < 4 {
+		d.err = errors.New("short sftp packet")
+		return 0
+	}
+	v := binary.BigEndian.Uint32(d.b)
+	d.b = d.b[4:]
+	return v
+}
+
+func (d *sftpDecoder) uint64() uint64 {
+	return uint64(d.uint32())<<32 | uint64(d.uint32())
+}
+
+func (d *sftpDecoder) string() string {
+	n := d.uint32()
+	if d.err != nil || uint32(len(d.b)) < n {
+		d.err = errors.New("short sftp packet")
+		return ""
+	}
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s
+}
+
+func (d *sftpDecoder) attrs() sftpAttributes {
+	var a sftpAttributes
+
+	flags := d.uint32()
+	if flags&sftpAttrSize != 0 {
+		a.size = int64(d.uint64())
+	}
+	if flags&sftpAttrUIDGID != 0 {
+		d.uint32()
+		d.uint32()
+	}
+	if flags&sftpAttrPermissions != 0 {
+		a.mode = d.uint32()
+		a.dir = a.mode&0170000 == 0040000
+		a.regular = a.mode&0170000 == 0100000
+	}
+	if flags&sftpAttrACModTime != 0 {
+		d.uint32()
+		a.modTime = time.Unix(int64(d.uint32()), 0)
+	}
+	if flags&sftpAttrExtended != 0 {
+		for n := d.uint32(); n > 0 && d.err == nil; n-- {
+			d.string()
+			d.string()
+		}
+	}
+	return a
+}
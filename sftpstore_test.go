@@ -0,0 +1,351 @@
+package loggeradapter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sftpServer is an SFTP stand-in serving a local directory.
+type sftpServer struct {
+	root string
+
+	mu      sync.Mutex
+	handles map[string]*sftpServerHandle
+	next    int
+}
+
+type sftpServerHandle struct {
+	file    *os.File
+	entries []os.DirEntry
+	dir     string
+	listed  bool
+}
+
+func newSFTPTestStore(t *testing.T, dir string) (*SFTPStore, string) {
+	t.Helper()
+
+	root := t.TempDir()
+	srv := &sftpServer{root: root, handles: make(map[string]*sftpServerHandle)}
+	store, err := NewSFTPStore(SFTPConfig{
+		Dir: dir,
+		Dial: func(context.Context) (io.ReadWriteCloser, error) {
+			client, server := net.Pipe()
+			go srv.serve(server)
+			return client, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, root
+}
+
+func (s *sftpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return
+		}
+		packet := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(r, packet); err != nil {
+			return
+		}
+
+		typ, payload := packet[0], packet[1:]
+		if typ == sftpInit {
+			if writeSFTPPacket(conn, sftpVersionP, appendUint32(nil, sftpVersion)) != nil {
+				return
+			}
+			continue
+		}
+
+		d := sftpDecoder{b: payload}
+		id := d.uint32()
+		rtyp, resp := s.handle(typ, &d)
+		if writeSFTPPacket(conn, rtyp, append(appendUint32(nil, id), resp...)) != nil {
+			return
+		}
+	}
+}
+
+func writeSFTPPacket(w io.Writer, typ byte, payload []byte) error {
+	packet := appendUint32(nil, uint32(1+len(payload)))
+	packet = append(packet, typ)
+	_, err := w.Write(append(packet, payload...))
+	return err
+}
+
+func sftpStatusPacket(err error) (byte, []byte) {
+	code := uint32(sftpStatusOK)
+	msg := ""
+	switch {
+	case err == io.EOF:
+		code = sftpStatusEOF
+	case errors.Is(err, fs.ErrNotExist):
+		code, msg = sftpStatusNoSuchFile, err.Error()
+	case err != nil:
+		code, msg = 4, err.Error()
+	}
+	return sftpStatus, appendSFTPString(appendSFTPString(appendUint32(nil, code), msg), "")
+}
+
+func sftpTestAttrs(info os.FileInfo) []byte {
+	mode := uint32(info.Mode().Perm())
+	switch {
+	case info.IsDir():
+		mode |= 0040000
+	case info.Mode().IsRegular():
+		mode |= 0100000
+	}
+
+	b := appendUint32(nil, sftpAttrSize|sftpAttrPermissions|sftpAttrACModTime)
+	b = appendUint64(b, uint64(info.Size()))
+	b = appendUint32(b, mode)
+	t := uint32(info.ModTime().Unix())
+	return appendUint32(appendUint32(b, t), t)
+}
+
+func (s *sftpServer) path(p string) string {
+	return filepath.Join(s.root, filepath.FromSlash(p))
+}
+
+func (s *sftpServer) handle(typ byte, d *sftpDecoder) (byte, []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch typ {
+	case sftpOpen:
+		p := d.string()
+		flags := d.uint32()
+		mode := os.O_RDONLY
+		if flags&sftpFlagWrite != 0 {
+			mode = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		}
+		file, err := os.OpenFile(s.path(p), mode, 0644)
+		if err != nil {
+			return sftpStatusPacket(err)
+		}
+		return s.newHandle(&sftpServerHandle{file: file})
+	case sftpOpendir:
+		p := d.string()
+		entries, err := os.ReadDir(s.path(p))
+		if err != nil {
+			return sftpStatusPacket(err)
+		}
+		return s.newHandle(&sftpServerHandle{entries: entries, dir: s.path(p)})
+	case sftpReaddir:
+		h := s.handles[d.string()]
+		if h.listed {
+			return sftpStatusPacket(io.EOF)
+		}
+		h.listed = true
+		resp := appendUint32(nil, uint32(len(h.entries)))
+		for _, e := range h.entries {
+			info, _ := e.Info()
+			resp = appendSFTPString(resp, e.Name())
+			resp = appendSFTPString(resp, e.Name())
+			resp = append(resp, sftpTestAttrs(info)...)
+		}
+		return sftpName, resp
+	case sftpClose:
+		id := d.string()
+		if h := s.handles[id]; h != nil && h.file != nil {
+			_ = h.file.Close()
+		}
+		delete(s.handles, id)
+		return sftpStatusPacket(nil)
+	case sftpWrite:
+		h := s.handles[d.string()]
+		offset := d.uint64()
+		_, err := h.file.WriteAt([]byte(d.string()), int64(offset))
+		return sftpStatusPacket(err)
+	case sftpRead:
+		h := s.handles[d.string()]
+		offset := d.uint64()
+		buf := make([]byte, d.uint32())
+		n, err := h.file.ReadAt(buf, int64(offset))
+		if n == 0 {
+			return sftpStatusPacket(err)
+		}
+		return sftpData, appendSFTPString(nil, string(buf[:n]))
+	case sftpStat:
+		info, err := os.Stat(s.path(d.string()))
+		if err != nil {
+			return sftpStatusPacket(err)
+		}
+		return sftpAttrs, sftpTestAttrs(info)
+	case sftpMkdir:
+		return sftpStatusPacket(os.Mkdir(s.path(d.string()), 0755))
+	case sftpRmdir:
+		return sftpStatusPacket(os.Remove(s.path(d.string())))
+	case sftpRemove:
+		return sftpStatusPacket(os.Remove(s.path(d.string())))
+	case sftpRename:
+		from, to := s.path(d.string()), s.path(d.string())
+		if _, err := os.Stat(to); err == nil {
+			return sftpStatusPacket(errors.New("target exists"))
+		}
+		return sftpStatusPacket(os.Rename(from, to))
+	default:
+		return sftpStatusPacket(errors.New("unsupported operation"))
+	}
+}
+
+func (s *sftpServer) newHandle(h *sftpServerHandle) (byte, []byte) {
+	s.next++
+	id := strconv.Itoa(s.next)
+	s.handles[id] = h
+	return sftpHandle, appendSFTPString(nil, id)
+}
+
+func TestSFTPStore(t *testing.T) {
+	store, root := newSFTPTestStore(t, "upload")
+	ctx := context.Background()
+
+	large := bytes.Repeat([]byte("0123456789"), 10000)
+	for name, content := range map[string][]byte{
+		"a.tar.gz":         []byte("small"),
+		"2024/01/b.tar.gz": large,
+	} {
+		if err := store.Put(ctx, name, bytes.NewReader(content)); err != nil {
+			t.Fatalf("put %s: %v", name, err)
+		}
+	}
+	// replacing a file
+	if err := store.Put(ctx, "a.tar.gz", strings.NewReader("replaced")); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := os.ReadFile(filepath.Join(root, "upload", "2024", "01", "b.tar.gz")); !bytes.Equal(b, large) {
+		t.Errorf("uploaded %d bytes, expected %d", len(b), len(large))
+	}
+
+	infos, err := store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	if len(infos) != 2 || infos[0].Name != "2024/01/b.tar.gz" || infos[0].Size != int64(len(large)) ||
+		infos[1].Name != "a.tar.gz" || infos[1].ModTime.IsZero() {
+		t.Fatalf("unexpected listing %+v", infos)
+	}
+
+	r, err := store.Open(ctx, "2024/01/b.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(r)
+	_ = r.Close()
+	if err != nil || !bytes.Equal(b, large) {
+		t.Errorf("downloaded %d bytes, expected %d: %v", len(b), len(large), err)
+	}
+
+	if err = store.Delete(ctx, "2024/01/b.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Delete(ctx, "2024/01/b.tar.gz"); err != nil {
+		t.Errorf("deleting a missing file failed: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(root, "upload", "2024")); !os.IsNotExist(err) {
+		t.Errorf("expected empty directories to be removed, got %v", err)
+	}
+	if _, err = store.Open(ctx, "2024/01/b.tar.gz"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected a deleted archive not to exist, got %v", err)
+	}
+}
+
+func TestArchiveSFTPStore(t *testing.T) {
+	store, root := newSFTPTestStore(t, "upload")
+	dir := writeArchivedBackups(t, Config{ArchiveChecksum: true}, map[string]string{
+		"app-2024-01-01T10.log": "first\n",
+		"app-2024-01-01T11.log": "second\n",
+	}, WithArchiveStore(store))
+
+	if files, _ := filepath.Glob(filepath.Join(dir, "app-*")); len(files) != 0 {
+		t.Errorf("expected no local backups or archives, got %v", files)
+	}
+
+	archive := filepath.Join(root, "upload", "app-2024-01-01T14-00-00.tar.gz")
+	if _, err := VerifyArchive(archive); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(archive + checksumSuffix); err != nil {
+		t.Errorf("expected the checksum to be uploaded: %v", err)
+	}
+}
+
+func TestSFTPStoreContext(t *testing.T) {
+	store, err := NewSFTPStore(SFTPConfig{
+		Dial: func(context.Context) (io.ReadWriteCloser, error) {
+			// a server that never answers
+			client, _ := net.Pipe()
+			return client, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = store.List(ctx); err == nil {
+		t.Error("expected the operation to end with the context")
+	}
+}
+
+func TestSFTPStoreConfigError(t *testing.T) {
+	for _, host := range []string{"", "-oProxyCommand=touch /tmp/pwned"} {
+		var cfgErr *ConfigError
+		if _, err := NewSFTPStore(SFTPConfig{Host: host}); !errors.As(err, &cfgErr) || cfgErr.Field != "Host" {
+			t.Errorf("%q: expected a Host config error, got %v", host, err)
+		}
+	}
+}
+
+func TestSFTPStoreCommandError(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+
+	// ssh refusing the key exits before the handshake
+	store, err := NewSFTPStore(SFTPConfig{
+		Command: []string{"sh", "-c", "echo 'Permission denied (publickey).' >&2; exit 255"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.List(context.Background()); err == nil || !strings.Contains(err.Error(), "Permission denied") {
+		t.Errorf("expected the handshake error to tell why, got %v", err)
+	}
+
+	conn, err := startSFTPCommand([]string{"sh", "-c", "cat >/dev/null; echo closing >&2; exit 3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conn.Close()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || !strings.Contains(err.Error(), "closing") {
+		t.Errorf("expected Close to report the exit status and stderr, got %v", err)
+	}
+	if again := conn.Close(); again != err {
+		t.Errorf("expected Close to report the same error again, got %v", again)
+	}
+}
@@ -0,0 +1,51 @@
+//go:build !windows && !plan9 && !js && !wasip1
+
+package loggeradapter
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// HandleSignals reopens the log file of w on SIGHUP and rotates it on SIGUSR1,
+// errors are reported to the writer's ErrorHandler. Calling stop uninstalls
+// the handler.
+func HandleSignals(w *Writer) (stop func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP, syscall.SIGUSR1)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-ch:
+				w.handleSignal(sig)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
+
+func (w *Writer) handleSignal(sig os.Signal) {
+	switch sig {
+	case syscall.SIGHUP:
+		if err := w.Reopen(); err != nil {
+			w.opts.errorHandler("reopen", err)
+		}
+	case syscall.SIGUSR1:
+		if err := w.Rotate(); err != nil {
+			w.opts.errorHandler("rotate", err)
+		}
+	}
+}
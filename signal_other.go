@@ -0,0 +1,8 @@
+//go:build windows || plan9 || js || wasip1
+
+package loggeradapter
+
+// HandleSignals is a no-op on platforms without SIGHUP and SIGUSR1.
+func HandleSignals(_ *Writer) (stop func()) {
+	return func() {}
+}
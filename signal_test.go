@@ -0,0 +1,40 @@
+//go:build !windows && !plan9 && !js && !wasip1
+
+package loggeradapter
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHandleSignals(t *testing.T) {
+	dir := t.TempDir()
+
+	w, err := NewWriter(Config{Filename: filepath.Join(dir, "app.log")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	stop := HandleSignals(w)
+	defer stop()
+
+	if _, err = w.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err = syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if backups, _ := filepath.Glob(filepath.Join(dir, "app-*.log")); len(backups) == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("expected SIGUSR1 to rotate the log file")
+}
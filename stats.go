@@ -0,0 +1,35 @@
+package loggeradapter
+
+import "sync/atomic"
+
+// Stats reports counters of a Writer's background work.
+type Stats struct {
+	// ArchivePasses is the number of archive passes attempted, retries included.
+	ArchivePasses int64
+	// ArchiveErrors is the number of archive passes that returned an error.
+	ArchiveErrors int64
+	// ArchiveRetries is the number of times a failed pass was retried.
+	ArchiveRetries int64
+	// ArchiveFailures is the number of passes given up after all retries,
+	// each of them is reported to the ErrorHandler.
+	ArchiveFailures int64
+
+	// DroppedBytes and DroppedWrites count the writes discarded by the
+	// overflow policy of the asynchronous mode.
+	DroppedBytes  int64
+	DroppedWrites int64
+}
+
+type archiveStats struct {
+	passes   int64
+	errors   int64
+	retries  int64
+	failures int64
+}
+
+func (s *archiveStats) snapshot(stats *Stats) {
+	stats.ArchivePasses = atomic.LoadInt64(&s.passes)
+	stats.ArchiveErrors = atomic.LoadInt64(&s.errors)
+	stats.ArchiveRetries = atomic.LoadInt64(&s.retries)
+	stats.ArchiveFailures = atomic.LoadInt64(&s.failures)
+}
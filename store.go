@@ -0,0 +1,290 @@
+package loggeradapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ArchiveStore is the storage archives are written to and pruned from.
+// Names are slash separated paths relative to the root of the store, such as
+// app-2024-01-01T10-00-00.tar.gz or 2024/01/app-2024-01-01T10-00-00.tar.gz.
+type ArchiveStore interface {
+	// Put stores the content read from r under name, replacing any file of
+	// the same name.
+	Put(ctx context.Context, name string, r io.Reader) error
+	// List returns all files of the store, in any order.
+	List(ctx context.Context) ([]ArchiveInfo, error)
+	// Delete removes the file, it's not an error for it not to exist.
+	Delete(ctx context.Context, name string) error
+	// Open opens the file for reading.
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// ArchiveInfo describes a file of an ArchiveStore.
+type ArchiveInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// LocalStore stores archives in a directory of the local file system, it's
+// the store used by default.
+type LocalStore struct {
+	dir   string
+	depth int // directories below dir that are listed, -1 lists all
+	mode  os.FileMode
+}
+
+// NewLocalStore returns a store keeping archives below dir.
+func NewLocalStore(dir string) *LocalStore {
+	return &LocalStore{dir: dir, depth: -1, mode: defaultFileMode}
+}
+
+// Dir returns the directory of the store.
+func (s *LocalStore) Dir() string {
+	return s.dir
+}
+
+func (s *LocalStore) path(name string) (string, error) {
+	if err := checkArchiveName(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, filepath.Clean(filepath.FromSlash(name))), nil
+}
+
+// checkArchiveName rejects names escaping the root of a store.
+func checkArchiveName(name string) error {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if name == "" || filepath.IsAbs(clean) || strings.HasPrefix(name, "/") ||
+		clean == ".." || strings.HasPrefix(clean, ".."+string(os.PathSeparator)) {
+		return fmt.Errorf("invalid archive name %q", name)
+	}
+	return nil
+}
+
+// Put writes the file under a temporary name, syncs it and renames it into place.
+func (s *LocalStore) Put(_ context.Context, name string, r io.Reader) (err error) {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	tmp := path + archiveTempSuffix
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, s.mode)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = file.Close()
+			_ = os.Remove(tmp)
+		}
+	}()
+
+	if _, err = io.Copy(file, r); err != nil {
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+func (s *LocalStore) List(_ context.Context) ([]ArchiveInfo, error) {
+	var infos []ArchiveInfo
+
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == s.dir {
+				return err
+			}
+			return nil
+		}
+
+		rel, relErr := filepath.Rel(s.dir, path)
+		if relErr != nil || rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if s.depth >= 0 && strings.Count(rel, "/") >= s.depth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		fi, infoErr := d.Info()
+		if infoErr != nil || !fi.Mode().IsRegular() {
+			return nil
+		}
+		infos = append(infos, ArchiveInfo{Name: rel, Size: fi.Size(), ModTime: fi.ModTime()})
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	return infos, nil
+}
+
+// Delete removes the file and the directories it leaves empty.
+func (s *LocalStore) Delete(_ context.Context, name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	root := filepath.Clean(s.dir)
+	for dir := filepath.Dir(path); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *LocalStore) Open(_ context.Context, name string) (io.ReadCloser, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// listArchives returns the archives of the store matching the archive
+// pattern, newest first.
+func (a *archiver) listArchives() ([]logInfo, error) {
+	infos, err := a.store.List(a.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't list archives: %w", err)
+	}
+
+	suffixes := a.archives.suffixList()
+	files := make([]logInfo, 0, len(infos))
+	for _, info := range infos {
+		t, seq, ok := a.archives.parseSuffixed(info.Name, suffixes)
+		if !ok {
+			continue
+		}
+		files = append(files, logInfo{
+			timestamp: t,
+			seq:       seq,
+			path:      a.archivePath(info.Name),
+			rel:       info.Name,
+			FileInfo:  memFileInfo{name: path.Base(info.Name), size: info.Size, mode: a.fileMode, modTime: info.ModTime},
+		})
+	}
+
+	sort.Sort(byFormatTime(files))
+	return files, nil
+}
+
+// archivePath returns the local path of an archive, or its name in a remote store.
+func (a *archiver) archivePath(name string) string {
+	if a.local != nil {
+		if p, err := a.local.path(name); err == nil {
+			return p
+		}
+	}
+	return name
+}
+
+// archiveExists returns whether an archive name is taken, names in a remote
+// store are looked up in the index.
+func (a *archiver) archiveExists() (func(name string) bool, error) {
+	if a.local != nil {
+		return func(name string) bool { return fileExists(a.archivePath(name)) }, nil
+	}
+
+	archives, err := a.indexedArchives()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(archives))
+	for _, f := range archives {
+		names[f.rel] = true
+	}
+	return func(name string) bool { return names[name] }, nil
+}
+
+// archiveName returns the name of a new archive, archives created at the
+// same time get a sequence number.
+func (a *archiver) archiveName(exists func(name string) bool) string {
+	now := a.now()
+	for seq := 0; ; seq++ {
+		rel, _ := filepath.Rel(a.archives.root, a.archives.format(now, seq))
+		if name := filepath.ToSlash(rel); !exists(name) {
+			return name
+		}
+	}
+}
+
+// stagingPath returns where an archive is built: in place in a local store,
+// in the staging directory next to the log file otherwise.
+func (a *archiver) stagingPath(name string) string {
+	if a.local != nil {
+		return a.archivePath(name)
+	}
+	return filepath.Join(a.stagingDir, filepath.FromSlash(name))
+}
+
+// upload puts an archive built in the staging directory into the store, its
+// sidecars go first so a listed archive always has them.
+func (a *archiver) upload(name, path string) error {
+	if a.local != nil {
+		return nil
+	}
+
+	for _, suffix := range append(sidecarSuffixes, "") {
+		file, err := os.Open(path + suffix)
+		if os.IsNotExist(err) && suffix != "" {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		err = a.store.Put(a.ctx, name+suffix, file)
+		_ = file.Close()
+		if err != nil {
+			return fmt.Errorf("can't upload archive %s: %w", name+suffix, err)
+		}
+	}
+	return nil
+}
+
+// deleteArchive removes an archive and its sidecars from the store.
+func (a *archiver) deleteArchive(name string) error {
+	err := a.store.Delete(a.ctx, name)
+	for _, suffix := range sidecarSuffixes {
+		_ = a.store.Delete(a.ctx, name+suffix)
+	}
+	if err == nil {
+		a.forgetArchive(name)
+	}
+	return err
+}
@@ -0,0 +1,238 @@
+package loggeradapter
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memoryStore struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{files: make(map[string][]byte)}
+}
+
+func (s *memoryStore) Put(_ context.Context, name string, r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.files[name] = b
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryStore) List(_ context.Context) ([]ArchiveInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	infos := make([]ArchiveInfo, 0, len(s.files))
+	for name, b := range s.files {
+		infos = append(infos, ArchiveInfo{Name: name, Size: int64(len(b))})
+	}
+	return infos, nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, name string) error {
+	s.mu.Lock()
+	delete(s.files, name)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryStore) Open(_ context.Context, name string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.files[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (s *memoryStore) names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.files))
+	for name := range s.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestLocalStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocalStore(t.TempDir())
+
+	if err := store.Put(ctx, "2024/01/a.tar.gz", bytes.NewReader([]byte("archive"))); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"", "../a.tar.gz", "/a.tar.gz"} {
+		if err := store.Put(ctx, name, bytes.NewReader(nil)); err == nil {
+			t.Errorf("expected name %q to be rejected", name)
+		}
+	}
+
+	infos, err := store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Name != "2024/01/a.tar.gz" || infos[0].Size != 7 {
+		t.Fatalf("unexpected files %+v", infos)
+	}
+
+	r, err := store.Open(ctx, "2024/01/a.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(r)
+	_ = r.Close()
+	if string(b) != "archive" {
+		t.Errorf("unexpected content %q", b)
+	}
+
+	if err = store.Delete(ctx, "2024/01/a.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Delete(ctx, "2024/01/a.tar.gz"); err != nil {
+		t.Errorf("deleting a missing file failed: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(store.Dir(), "2024")); !os.IsNotExist(err) {
+		t.Errorf("expected empty directories to be removed, got %v", err)
+	}
+}
+
+func TestArchiveDir(t *testing.T) {
+	archiveDir := filepath.Join(t.TempDir(), "archives")
+	dir := writeArchivedBackups(t, Config{ArchiveDir: archiveDir}, map[string]string{
+		"app-2024-01-01T10.log": "first\n",
+		"app-2024-01-01T11.log": "second\n",
+	})
+
+	if files, _ := filepath.Glob(filepath.Join(dir, "app-*")); len(files) != 0 {
+		t.Errorf("expected the log directory to hold no backups or archives, got %v", files)
+	}
+
+	archive := filepath.Join(archiveDir, "app-2024-01-01T14-00-00.tar.gz")
+	if _, err := VerifyArchive(archive); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveDirCompress(t *testing.T) {
+	archiveDir := filepath.Join(t.TempDir(), "archives")
+	writeArchivedBackups(t, Config{ArchiveDir: archiveDir, Compress: true}, map[string]string{
+		"app-2024-01-01T10.log": "first\n",
+	})
+
+	if _, err := VerifyArchive(filepath.Join(archiveDir, "app-2024-01-01T10.log.gz")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveStore(t *testing.T) {
+	dir := t.TempDir()
+	store := newMemoryStore()
+
+	var mu sync.Mutex
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+	clock := ClockFunc(func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	})
+
+	w, err := NewWriter(Config{
+		Filename:        filepath.Join(dir, "app.log"),
+		Rotation:        "1h",
+		Backup:          "1",
+		Archive:         "2",
+		ArchiveChecksum: true,
+	}, WithClock(clock), WithArchiveStore(store), WithArchiveOnShutdown())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, err = w.Write([]byte("hello\n")); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		now = now.Add(time.Hour)
+		mu.Unlock()
+		if err = w.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.archiver.runArchive(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"app-2024-01-01T14-00-00.tar.gz",
+		"app-2024-01-01T14-00-00.tar.gz" + checksumSuffix,
+		"app-2024-01-01T15-00-00.tar.gz",
+		"app-2024-01-01T15-00-00.tar.gz" + checksumSuffix,
+	}
+	names := store.names()
+	if len(names) != len(want) {
+		t.Fatalf("expected %v in the store, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("expected %v in the store, got %v", want, names)
+		}
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, ".app.log.staging")); err == nil {
+		entries, _ := os.ReadDir(filepath.Join(dir, ".app.log.staging"))
+		if len(entries) != 0 {
+			t.Errorf("expected staged archives to be removed, got %d", len(entries))
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "app-*.tar.gz*")); len(files) != 0 {
+		t.Errorf("expected no local archives, got %v", files)
+	}
+
+	// the chain continues from the archive in the store
+	r, _ := store.Open(context.Background(), want[2])
+	defer r.Close()
+	codec, _ := LookupCodec("tar.gz")
+	ar, err := codec.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		name, content, err := ar.Next()
+		if err != nil {
+			t.Fatalf("no manifest in %s: %v", want[2], err)
+		}
+		if name != ManifestName {
+			continue
+		}
+		m, err := decodeManifest(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.PreviousArchive != want[0] || m.Sequence < 2 {
+			t.Errorf("unexpected chain link %+v", m)
+		}
+		break
+	}
+}
@@ -0,0 +1,239 @@
+package loggeradapter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collectLines returns the lines of the log file, backups and archives in dir.
+func collectLines(t *testing.T, dir string) []string {
+	t.Helper()
+
+	var lines []string
+	scan := func(r io.Reader) {
+		s := bufio.NewScanner(r)
+		for s.Scan() {
+			lines = append(lines, s.Text())
+		}
+	}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		switch {
+		case strings.HasSuffix(path, ".log"):
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			scan(file)
+		case strings.HasSuffix(path, ".gz"):
+			r, err := OpenArchive(path, nil)
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			for {
+				name, mr, err := r.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					return err
+				}
+				if name != ManifestName {
+					scan(mr)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+// TestConcurrentUse hammers a writer with writes, rotations, reopens, syncs
+// and archive passes at once, every line written must end up exactly once in
+// the log file, a backup or an archive. Run it with -race.
+func TestConcurrentUse(t *testing.T) {
+	const (
+		writers = 8
+		lines   = 300
+	)
+
+	for _, tc := range []struct {
+		name string
+		cfg  Config
+		opts []Option
+	}{
+		{"rotate", Config{Rotation: "1kb"}, nil},
+		{"archive", Config{Rotation: "1kb", Backup: "2", Archive: "1000"}, nil},
+		{"compress", Config{Rotation: "1kb", Backup: "2", Compress: true}, nil},
+		{"async", Config{Rotation: "1kb", Backup: "2", Archive: "1000"},
+			[]Option{WithAsync(AsyncConfig{BufferSize: 4096, BatchSize: 512, FlushInterval: time.Millisecond})}},
+		{"async-drop", Config{Rotation: "1kb"},
+			[]Option{WithAsync(AsyncConfig{BufferSize: 256, FlushInterval: time.Millisecond, Overflow: OverflowDropOldest})}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tc.cfg.Filename = filepath.Join(dir, "app.log")
+			opts := append([]Option{
+				WithArchiveInterval(time.Millisecond, 0),
+				WithArchiveOnShutdown(),
+				WithErrorHandler(func(op string, err error) { t.Errorf("%s: %v", op, err) }),
+			}, tc.opts...)
+			w, err := NewWriter(tc.cfg, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var (
+				wg, background sync.WaitGroup
+				stop           = make(chan struct{})
+			)
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < lines; j++ {
+						if _, err := fmt.Fprintf(w, "writer %d line %03d\n", i, j); err != nil {
+							t.Error(err)
+							return
+						}
+					}
+				}(i)
+			}
+
+			background.Add(2)
+			go func() {
+				defer background.Done()
+				for i := 0; ; i++ {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					var err error
+					switch i % 4 {
+					case 0:
+						err = w.Rotate()
+					case 1:
+						err = w.Reopen()
+					case 2:
+						err = w.Sync()
+					case 3:
+						_ = w.Stats()
+					}
+					if err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+			go func() {
+				defer background.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					if w.archiver != nil {
+						if err := w.archiver.runArchive(); err != nil {
+							t.Error(err)
+							return
+						}
+					}
+					time.Sleep(time.Millisecond)
+				}
+			}()
+
+			wg.Wait()
+			close(stop)
+			background.Wait()
+			if err = w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			got := collectLines(t, dir)
+			seen := make(map[string]bool, len(got))
+			for _, line := range got {
+				if seen[line] || !strings.HasPrefix(line, "writer ") {
+					t.Fatalf("unexpected line %q", line)
+				}
+				seen[line] = true
+			}
+			stats := w.Stats()
+			if int64(len(got))+stats.DroppedWrites != writers*lines {
+				t.Errorf("expected %d lines, got %d and %d dropped", writers*lines, len(got), stats.DroppedWrites)
+			}
+			if stats.ArchiveFailures != 0 {
+				t.Errorf("expected no failed archive passes, got %d", stats.ArchiveFailures)
+			}
+		})
+	}
+}
+
+// TestConcurrentClose closes a writer while it's written to, writes either
+// succeed or fail with os.ErrClosed.
+func TestConcurrentClose(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		t.Run(fmt.Sprintf("async=%v", async), func(t *testing.T) {
+			var opts []Option
+			if async {
+				opts = append(opts, WithAsync(AsyncConfig{BufferSize: 1024, FlushInterval: time.Millisecond}))
+			}
+			w, err := NewWriter(Config{
+				Filename: filepath.Join(t.TempDir(), "app.log"),
+				Rotation: "1kb",
+				Backup:   "1",
+				Archive:  "1",
+			}, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						if _, err := w.Write([]byte("hello\n")); err != nil {
+							if !errors.Is(err, os.ErrClosed) {
+								t.Error(err)
+							}
+							return
+						}
+					}
+				}()
+			}
+
+			time.Sleep(20 * time.Millisecond)
+			var closers sync.WaitGroup
+			for i := 0; i < 2; i++ {
+				closers.Add(1)
+				go func() {
+					defer closers.Done()
+					if err := w.Close(); err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+			closers.Wait()
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,388 @@
+package loggeradapter
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ArchiveUploader ships finished archives to another system, such as an HTTP
+// endpoint. Unlike an ArchiveStore, archives stay in the local store and are
+// pruned there.
+type ArchiveUploader interface {
+	// Upload ships the file at path, name is its name in the archive store.
+	// A failed upload is kept and retried by Resume.
+	Upload(ctx context.Context, name, path string) error
+	// Resume retries the uploads left pending by failures or a restart.
+	// Uploads whose file is gone are dropped and reported with a
+	// *LostUploadError.
+	Resume(ctx context.Context) error
+}
+
+// LostUploadError reports pending uploads given up because their file was
+// removed before they could be retried, such as by the retention policy. Err
+// is the failure of the other uploads, if any.
+type LostUploadError struct {
+	Names []string
+	Err   error
+}
+
+func (e *LostUploadError) Error() string {
+	msg := "pending uploads lost, their files were removed: " + strings.Join(e.Names, ", ")
+	if e.Err != nil {
+		msg += "; " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *LostUploadError) Unwrap() error {
+	return e.Err
+}
+
+// HTTPUploaderConfig configures an HTTPUploader.
+type HTTPUploaderConfig struct {
+	// URL is the template of the URL archives are PUT to, {name} is the
+	// escaped name of the archive, such as 2024/01/app-2024-01-01T10-00-00.tar.gz,
+	// and {base} its last element.
+	URL string
+	// Header is sent with every request, such as an Authorization header.
+	Header http.Header
+
+	// CertFile and KeyFile are the client certificate of mutual TLS, CAFile
+	// holds the certificates the server is verified with instead of the
+	// system roots. TLSConfig is used as is when it's set.
+	CertFile  string
+	KeyFile   string
+	CAFile    string
+	TLSConfig *tls.Config
+
+	// Attempts is the number of tries of an upload, 3 by default. The wait
+	// between tries starts at Backoff, 1s by default, and doubles up to
+	// MaxBackoff, 30s by default.
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// StateFile persists the pending uploads, so a restart resumes them.
+	StateFile string
+	// Timeout bounds each request, 5m by default.
+	Timeout time.Duration
+}
+
+// HTTPUploader uploads archives with HTTP PUT requests. Failed uploads are
+// retried with backoff and recorded in the state file until they succeed,
+// uploads rejected with a 4xx status other than 408 and 429 are given up.
+type HTTPUploader struct {
+	url        string
+	header     http.Header
+	client     *http.Client
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	stateFile  string
+
+	mu      sync.Mutex
+	pending []pendingUpload
+}
+
+type pendingUpload struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+type uploaderState struct {
+	Pending []pendingUpload `json:"pending"`
+}
+
+// HTTPStatusError is the unexpected status of an HTTP upload.
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("upload to %s failed with status %d: %s", e.URL, e.StatusCode, e.Body)
+}
+
+// NewHTTPUploader returns an uploader, the uploads pending in the state file
+// are resumed by the first call to Resume.
+func NewHTTPUploader(cfg HTTPUploaderConfig) (*HTTPUploader, error) {
+	if !strings.HasPrefix(cfg.URL, "http://") && !strings.HasPrefix(cfg.URL, "https://") {
+		return nil, &ConfigError{Field: "URL", Value: cfg.URL, Reason: "expected an http or https URL"}
+	}
+
+	tlsConfig := cfg.TLSConfig
+	if tlsConfig == nil && (cfg.CertFile != "" || cfg.CAFile != "") {
+		var err error
+		if tlsConfig, err = loadTLSConfig(cfg.CertFile, cfg.KeyFile, cfg.CAFile); err != nil {
+			return nil, err
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Minute
+	}
+
+	u := &HTTPUploader{
+		url:        cfg.URL,
+		header:     cfg.Header,
+		client:     &http.Client{Transport: transport, Timeout: timeout},
+		attempts:   cfg.Attempts,
+		backoff:    cfg.Backoff,
+		maxBackoff: cfg.MaxBackoff,
+		stateFile:  cfg.StateFile,
+	}
+	if u.attempts <= 0 {
+		u.attempts = 3
+	}
+	if u.backoff <= 0 {
+		u.backoff = time.Second
+	}
+	if u.maxBackoff <= 0 {
+		u.maxBackoff = 30 * time.Second
+	}
+
+	if u.stateFile != "" {
+		content, err := os.ReadFile(u.stateFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			var state uploaderState
+			if err = json.Unmarshal(content, &state); err != nil {
+				return nil, fmt.Errorf("invalid upload state %s: %w", u.stateFile, err)
+			}
+			u.pending = state.Pending
+		}
+	}
+	return u, nil
+}
+
+func loadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, &ConfigError{Field: "CertFile", Value: certFile, Reason: err.Error()}
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, &ConfigError{Field: "CAFile", Value: caFile, Reason: err.Error()}
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, &ConfigError{Field: "CAFile", Value: caFile, Reason: "no certificates found"}
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// Pending returns the names of the uploads waiting to be retried.
+func (u *HTTPUploader) Pending() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	names := make([]string, 0, len(u.pending))
+	for _, p := range u.pending {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func (u *HTTPUploader) Upload(ctx context.Context, name, path string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// recorded first, so an upload cut short by a crash is resumed
+	u.pending = append(u.pending, pendingUpload{Name: name, Path: path})
+	if err := u.saveState(); err != nil {
+		return err
+	}
+
+	err := u.upload(ctx, name, path)
+	if err != nil && !permanentUploadError(err) {
+		return err
+	}
+	u.pending = u.pending[:len(u.pending)-1]
+	if saveErr := u.saveState(); err == nil {
+		err = saveErr
+	}
+	return err
+}
+
+// Resume retries the pending uploads in order, files removed in the meantime
+// are dropped and reported with a *LostUploadError.
+func (u *HTTPUploader) Resume(ctx context.Context) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var (
+		firstErr error
+		kept     []pendingUpload
+		lost     []string
+	)
+	for _, p := range u.pending {
+		err := u.upload(ctx, p.Name, p.Path)
+		switch {
+		case err == nil:
+		case os.IsNotExist(err):
+			lost = append(lost, p.Name)
+		case permanentUploadError(err):
+			if firstErr == nil {
+				firstErr = err
+			}
+		default:
+			if firstErr == nil {
+				firstErr = err
+			}
+			kept = append(kept, p)
+		}
+	}
+
+	if len(kept) == len(u.pending) && firstErr != nil {
+		return firstErr
+	}
+	u.pending = kept
+	if err := u.saveState(); firstErr == nil {
+		firstErr = err
+	}
+	if len(lost) > 0 {
+		return &LostUploadError{Names: lost, Err: firstErr}
+	}
+	return firstErr
+}
+
+func (u *HTTPUploader) saveState() error {
+	if u.stateFile == "" {
+		return nil
+	}
+
+	content, err := json.Marshal(uploaderState{Pending: u.pending})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(u.stateFile, content, defaultFileMode)
+}
+
+// upload tries the upload until it succeeds, fails permanently or runs out
+// of attempts.
+func (u *HTTPUploader) upload(ctx context.Context, name, path string) error {
+	backoff := u.backoff
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = u.put(ctx, name, path); err == nil || permanentUploadError(err) || os.IsNotExist(err) {
+			return err
+		}
+		if attempt >= u.attempts {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > u.maxBackoff {
+			backoff = u.maxBackoff
+		}
+	}
+}
+
+func (u *HTTPUploader) put(ctx context.Context, name, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	target := strings.NewReplacer(
+		"{name}", s3Escape(name, false),
+		"{base}", s3Escape(path.Base(name), true),
+	).Replace(u.url)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	for k, v := range u.header {
+		req.Header[k] = v
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &HTTPStatusError{URL: target, StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// permanentUploadError reports whether retrying can't help.
+func permanentUploadError(err error) bool {
+	var serr *HTTPStatusError
+	return errors.As(err, &serr) && serr.StatusCode >= 400 && serr.StatusCode < 500 &&
+		serr.StatusCode != http.StatusRequestTimeout && serr.StatusCode != http.StatusTooManyRequests
+}
+
+// ship hands the archives written by the pass to the uploader, sidecars go
+// first like uploads to a store.
+func (a *archiver) ship() error {
+	// lost uploads can't be retried, so they're reported without failing the pass
+	err := a.uploader.Resume(a.ctx)
+	var lost *LostUploadError
+	if errors.As(err, &lost) {
+		a.errorHandler("upload", &LostUploadError{Names: lost.Names})
+		err = lost.Err
+	}
+
+	for _, name := range a.unshipped {
+		for _, suffix := range append(sidecarSuffixes, "") {
+			path := a.archivePath(name) + suffix
+			if suffix != "" && !fileExists(path) {
+				continue
+			}
+			if uploadErr := a.uploader.Upload(a.ctx, name+suffix, path); err == nil {
+				err = uploadErr
+			}
+		}
+	}
+	a.unshipped = nil
+	return err
+}
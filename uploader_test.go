@@ -0,0 +1,390 @@
+package loggeradapter
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// uploadServer records the uploads it accepts, the first failures requests
+// get a 503.
+type uploadServer struct {
+	mu       sync.Mutex
+	files    map[string]string
+	headers  []http.Header
+	failures int
+	status   int
+}
+
+func (s *uploadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		return
+	}
+	if s.failures > 0 {
+		s.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	s.files[r.URL.EscapedPath()] = string(body)
+	s.headers = append(s.headers, r.Header.Clone())
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *uploadServer) uploaded() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files := make(map[string]string, len(s.files))
+	for k, v := range s.files {
+		files[k] = v
+	}
+	return files
+}
+
+func newUploadServer(t *testing.T) (*uploadServer, *httptest.Server) {
+	s := &uploadServer{files: make(map[string]string)}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+func TestHTTPUploader(t *testing.T) {
+	s, srv := newUploadServer(t)
+	s.failures = 2
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a b.tar.gz")
+	if err := os.WriteFile(file, []byte("archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := NewHTTPUploader(HTTPUploaderConfig{
+		URL:       srv.URL + "/upload/{name}?file={base}",
+		Header:    http.Header{"Authorization": {"Bearer token"}},
+		Attempts:  3,
+		Backoff:   time.Millisecond,
+		StateFile: filepath.Join(dir, "uploads.json"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = u.Upload(context.Background(), "2024/a b.tar.gz", file); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.uploaded()["/upload/2024/a%20b.tar.gz"]; got != "archive" {
+		t.Errorf("unexpected uploads %v", s.uploaded())
+	}
+	if h := s.headers[0]; h.Get("Authorization") != "Bearer token" || h.Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("unexpected headers %v", h)
+	}
+	if pending := u.Pending(); len(pending) != 0 {
+		t.Errorf("expected no pending uploads, got %v", pending)
+	}
+}
+
+func TestHTTPUploaderResume(t *testing.T) {
+	s, srv := newUploadServer(t)
+	s.failures = 100
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.tar.gz")
+	if err := os.WriteFile(file, []byte("archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := HTTPUploaderConfig{
+		URL:       srv.URL + "/{base}",
+		Attempts:  2,
+		Backoff:   time.Millisecond,
+		StateFile: filepath.Join(dir, "uploads.json"),
+	}
+
+	u, err := NewHTTPUploader(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = u.Upload(context.Background(), "a.tar.gz", file); err == nil {
+		t.Fatal("expected the upload to fail")
+	}
+	if pending := u.Pending(); len(pending) != 1 {
+		t.Fatalf("expected the upload to be pending, got %v", pending)
+	}
+
+	// a restart resumes the pending upload
+	s.mu.Lock()
+	s.failures = 0
+	s.mu.Unlock()
+	if u, err = NewHTTPUploader(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if pending := u.Pending(); len(pending) != 1 || pending[0] != "a.tar.gz" {
+		t.Fatalf("expected the upload to be pending after a restart, got %v", pending)
+	}
+	if err = u.Resume(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.uploaded()["/a.tar.gz"]; got != "archive" || len(u.Pending()) != 0 {
+		t.Errorf("expected the upload to be resumed, got %v pending %v", s.uploaded(), u.Pending())
+	}
+
+	// rejected uploads aren't retried
+	s.mu.Lock()
+	s.status = http.StatusForbidden
+	s.mu.Unlock()
+	if err = u.Upload(context.Background(), "a.tar.gz", file); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected the upload to be rejected, got %v", err)
+	}
+	if pending := u.Pending(); len(pending) != 0 {
+		t.Errorf("expected a rejected upload to be dropped, got %v", pending)
+	}
+}
+
+func writeTestCert(t *testing.T, dir, name string, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestHTTPUploaderMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	notAfter := time.Now().Add(time.Hour)
+
+	ca, caKey := writeTestCert(t, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil, nil)
+	writeTestCert(t, dir, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	writeTestCert(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	serverCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	s := &uploadServer{files: make(map[string]string)}
+	srv := httptest.NewUnstartedServer(s)
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	file := filepath.Join(dir, "a.tar.gz")
+	if err = os.WriteFile(file, []byte("archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := HTTPUploaderConfig{
+		URL:      srv.URL + "/{name}",
+		CAFile:   filepath.Join(dir, "ca.crt"),
+		Attempts: 1,
+	}
+	u, err := NewHTTPUploader(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = u.Upload(context.Background(), "a.tar.gz", file); err == nil {
+		t.Error("expected the upload without a client certificate to fail")
+	}
+
+	cfg.CertFile = filepath.Join(dir, "client.crt")
+	cfg.KeyFile = filepath.Join(dir, "client.key")
+	if u, err = NewHTTPUploader(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.Upload(context.Background(), "a.tar.gz", file); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.uploaded()["/a.tar.gz"]; got != "archive" {
+		t.Errorf("unexpected uploads %v", s.uploaded())
+	}
+}
+
+func TestArchiveHTTPUploader(t *testing.T) {
+	s, srv := newUploadServer(t)
+	u, err := NewHTTPUploader(HTTPUploaderConfig{URL: srv.URL + "/{name}", Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := writeArchivedBackups(t, Config{ArchiveChecksum: true}, map[string]string{
+		"app-2024-01-01T10.log": "first\n",
+	}, WithArchiveUploader(u))
+
+	archive := filepath.Join(dir, "app-2024-01-01T14-00-00.tar.gz")
+	content, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatalf("expected the archive to be kept: %v", err)
+	}
+
+	uploaded := s.uploaded()
+	if uploaded["/app-2024-01-01T14-00-00.tar.gz"] != string(content) {
+		t.Errorf("expected the archive to be uploaded, got %d files", len(uploaded))
+	}
+	if _, ok := uploaded["/app-2024-01-01T14-00-00.tar.gz"+checksumSuffix]; !ok {
+		t.Error("expected the checksum to be uploaded")
+	}
+
+	if _, err = NewWriter(Config{Filename: filepath.Join(dir, "app.log"), Backup: "1", Archive: "1"},
+		WithArchiveUploader(u), WithArchiveStore(newMemoryStore())); err == nil {
+		t.Error("expected an uploader to need the local store")
+	}
+}
+
+func TestArchiveUploaderShutdown(t *testing.T) {
+	started, cancelled := make(chan struct{}, 1), make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the server notices the client going away once the body is read
+		_, _ = io.Copy(io.Discard, r.Body)
+		started <- struct{}{}
+		<-r.Context().Done()
+		cancelled <- struct{}{}
+	}))
+	t.Cleanup(srv.Close)
+	u, err := NewHTTPUploader(HTTPUploaderConfig{URL: srv.URL + "/{name}", Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err = os.WriteFile(filepath.Join(dir, "app-2024-01-01T10.log"), []byte("first\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 1, 1, 14, 0, 0, 0, time.Local)
+	w, err := NewWriter(Config{Filename: filepath.Join(dir, "app.log"), Rotation: "1h", Backup: "1h", Archive: "10"},
+		WithClock(ClockFunc(func() time.Time { return now })), WithArchiveUploader(u))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first pass archives the backup and hangs uploading it
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = w.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected Shutdown to give up, got %v", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the upload to be cancelled")
+	}
+}
+
+func TestArchiveLostUpload(t *testing.T) {
+	_, srv := newUploadServer(t)
+	dir := t.TempDir()
+
+	// an upload left pending for an archive retention removed since
+	state := filepath.Join(dir, "uploads.json")
+	content := `{"pending":[{"name":"app-2024-01-01T09-00-00.tar.gz","path":"` +
+		filepath.ToSlash(filepath.Join(dir, "app-2024-01-01T09-00-00.tar.gz")) + `"}]}`
+	if err := os.WriteFile(state, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	u, err := NewHTTPUploader(HTTPUploaderConfig{URL: srv.URL + "/{name}", Backoff: time.Millisecond, StateFile: state})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending := u.Pending(); len(pending) != 1 {
+		t.Fatalf("expected a pending upload, got %v", pending)
+	}
+
+	var (
+		mu   sync.Mutex
+		lost []string
+	)
+	writeArchivedBackups(t, Config{}, map[string]string{"app-2024-01-01T10.log": "first\n"}, WithArchiveUploader(u),
+		WithErrorHandler(func(op string, err error) {
+			var lerr *LostUploadError
+			if op != "upload" || !errors.As(err, &lerr) {
+				t.Errorf("%s: %v", op, err)
+				return
+			}
+			mu.Lock()
+			lost = append(lost, lerr.Names...)
+			mu.Unlock()
+		}))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(lost) != 1 || lost[0] != "app-2024-01-01T09-00-00.tar.gz" {
+		t.Errorf("expected the lost upload to be reported, got %v", lost)
+	}
+	if pending := u.Pending(); len(pending) != 0 {
+		t.Errorf("expected the lost upload to be dropped, got %v", pending)
+	}
+}
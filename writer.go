@@ -1,60 +1,168 @@
 package loggeradapter
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 )
 
+// LoggerWriter is the writer returned by New, it satisfies zapcore.WriteSyncer
+// and is safe for concurrent use.
 type LoggerWriter interface {
-	io.Writer
+	io.WriteCloser
+	Sync() error
+	Shutdown(ctx context.Context) error
 }
 
 type Config struct {
 	Filename string
 	Rotation string
 	Backup   string
-	Archive  string
+	// Archive is the retention of archives: a count, an age, or a
+	// grandfather-father-son policy such as 48h:all,30d:daily,26w:weekly,36M:monthly.
+	Archive string
 
-	timeFormat string
+	// BackupPattern is the template of backup file names, such as
+	// {dir}/{prefix}.{time:20060102-150405}.{seq}{ext} or %Y/%m/%d/app-%H.log,
+	// it defaults to {dir}/{prefix}-{time}{ext}.
+	BackupPattern string
+
+	// ArchivePattern is the template of archive file names without the
+	// compression suffix, it takes the same placeholders as BackupPattern and
+	// defaults to {dir}/{prefix}-{time}, e.g. logs/app-2024-01-01T10-00-00.tar.gz.
+	ArchivePattern string
+	// ArchiveDir is the directory archives are written to, such as a mount of
+	// slower storage, it replaces {dir} in ArchivePattern and holds the
+	// compressed backups in Compress mode. It defaults to the directory of
+	// the log file and isn't used when WithArchiveStore sets another store.
+	ArchiveDir string
+
+	// ArchiveFormat selects the codec registered with RegisterCodec used to
+	// write archives: tar.gz (default), tar, zip or gz.
+	ArchiveFormat string
+	// ArchiveLevel is the compression level of the codec, 0 uses its default.
+	ArchiveLevel int
+	// ArchiveChecksum writes the SHA-256 of each archive to a sidecar file in
+	// the format of sha256sum, e.g. logs/app-2024-01-01T10-00-00.tar.gz.sha256.
+	ArchiveChecksum bool
+
+	// Compress compresses every backup on its own right after rotation instead
+	// of bundling them into archives, e.g. logs/app-2024-01-01T10.log.gz.
+	// Backup then keeps the newest backups uncompressed, like logrotate's
+	// delaycompress with Backup "1", and Archive prunes the compressed ones.
+	// ArchiveFormat defaults to gz in this mode.
+	Compress bool
+
+	// MaxTotalSize caps the size of the log file, backups and archives
+	// together, such as 5gb, and MinFreeSpace is the space to keep free on
+	// the file system of the log file. The oldest archives and then the
+	// oldest backups are removed until both hold.
+	MaxTotalSize string
+	MinFreeSpace string
+
+	// Location is the time zone of rotation boundaries, backup and archive
+	// names and the parsing of those names, it defaults to the local time zone.
+	Location *time.Location
+	// UTC uses UTC instead of Location.
+	UTC bool
+
+	// ErrorHandler receives errors from background archiving, they are
+	// written to stderr when it's nil.
+	ErrorHandler ErrorHandler
+
+	backupPattern *filePattern
 }
 
-type loggerWriter struct {
-	filename     string
-	rotator      *rotator
-	archiver     *archiver
-	maxSizeByte  int64
-	fileSizeByte int64
-	file         *os.File
+// Writer writes logs to Config.Filename, rotating and archiving them as configured.
+//
+// A Writer is safe for concurrent use by multiple goroutines in every
+// configuration: each Write reaches the file whole and rotations happen
+// between writes, Rotate, Reopen, Sync, Stats and Close may be called while
+// others write, and archive passes run one at a time in the background.
+// Writes racing with Close either succeed or fail with os.ErrClosed.
+type Writer struct {
+	filename    string
+	rotator     *rotator
+	archiver    *archiver
+	async       *asyncWriter
+	maxSizeByte int64
+	opts        options
 }
 
+// New creates a LoggerWriter and panics when the config is invalid,
+// use NewWriter to get the error instead.
 func New(cfg Config) LoggerWriter {
-	lw := &loggerWriter{filename: cfg.Filename}
+	w, err := NewWriter(cfg)
+	if err != nil {
+		panic(fmt.Sprintf("Create logger writer failed, error: %v", err))
+	}
+	return w
+}
 
-	if err := lw.openFile(); err != nil {
-		panic(fmt.Sprintf("Open log file failed, error: %v", err))
+// NewWriter validates cfg and creates a Writer, a *ConfigError is returned
+// when any field of cfg is invalid.
+func NewWriter(cfg Config, opts ...Option) (*Writer, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
 	}
 
+	lw := &Writer{filename: cfg.Filename, opts: newOptions(opts...)}
+	if lw.opts.errorHandler == nil {
+		lw.opts.errorHandler = cfg.ErrorHandler
+	}
+	if lw.opts.errorHandler == nil {
+		lw.opts.errorHandler = stderrErrorHandler
+	}
+	if cfg.UTC {
+		lw.opts.location = time.UTC
+	} else if cfg.Location != nil {
+		lw.opts.location = cfg.Location
+	}
+	if lw.filename == "" {
+		lw.filename = defaultFilename
+	}
 	cfg.Filename = lw.filename
-	lw.rotator = newRotator(cfg)
 
-	if lw.rotator != nil {
-		lw.rotator.file = lw.file
-		lw.rotator.fileSizeByte = lw.fileSizeByte
-		cfg.timeFormat = lw.rotator.timeFormat
-		lw.maxSizeByte = lw.rotator.maxSizeByte
+	rotator, err := newRotator(cfg, lw.opts)
+	if err != nil {
+		return nil, err
+	}
+	lw.rotator = rotator
+
+	if err = lw.rotator.open(); err != nil {
+		return nil, err
 	}
 
-	if lw.maxSizeByte == 0 {
-		lw.maxSizeByte = defaultMaxSizeByte
+	cfg.backupPattern = lw.rotator.backups
+	lw.maxSizeByte = lw.rotator.maxSizeByte
+
+	lw.archiver, err = newArchiver(cfg, lw.opts)
+	if err != nil {
+		_ = lw.rotator.shutdown()
+		return nil, err
+	}
+	if lw.opts.async != nil {
+		if lw.async, err = newAsyncWriter(lw.rotator, *lw.opts.async, lw.opts.errorHandler); err != nil {
+			_ = lw.rotator.shutdown()
+			return nil, err
+		}
+	}
+	if lw.archiver != nil {
+		if err = lw.archiver.recover(); err != nil {
+			lw.opts.errorHandler("recover", err)
+		}
+		// archive passes follow rotations rather than every write
+		lw.rotator.onRotate = lw.archiver.rotated
+		lw.archiver.start()
 	}
 
-	lw.archiver = newArchiver(cfg)
-	return lw
+	return lw, nil
 }
 
-func (w *loggerWriter) Write(p []byte) (n int, err error) {
+func (w *Writer) Write(p []byte) (n int, err error) {
 	writeLen := int64(len(p))
 
 	if w.maxSizeByte != 0 && writeLen > w.maxSizeByte {
@@ -63,43 +171,89 @@ func (w *loggerWriter) Write(p []byte) (n int, err error) {
 		)
 	}
 
-	if w.rotator != nil {
-		n, err = w.rotator.rotateWrite(p)
-		w.file = w.rotator.file
-		w.fileSizeByte = w.rotator.fileSizeByte
-	} else if w.file != nil {
-		n, err = w.file.Write(p)
-		w.fileSizeByte += int64(n)
+	if w.async != nil {
+		return w.async.write(p)
 	}
+	return w.rotator.rotateWrite(p)
+}
 
-	if w.archiver != nil {
-		w.archiver.archive()
+// flush writes out the buffer of the asynchronous mode.
+func (w *Writer) flush() error {
+	if w.async == nil {
+		return nil
 	}
+	return w.async.flush()
+}
 
-	return n, err
+// Rotate renames the current log file to a backup and opens a new one,
+// the same way a configured Rotation does.
+func (w *Writer) Rotate() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	return w.rotator.rotate()
 }
 
-func (w *loggerWriter) openFile() error {
-	if w.filename == "" {
-		w.filename = defaultFilename
+// Reopen closes and reopens the log file by name, so writes go to a new file
+// after the current one was moved by an external tool.
+func (w *Writer) Reopen() error {
+	if err := w.flush(); err != nil {
+		return err
 	}
+	return w.rotator.reopen()
+}
 
-	file, err := openFile(w.filename)
-	if err != nil {
-		return fmt.Errorf("can't open logfile: %s", err)
+// Stats returns counters of the writer's background archiving and of the
+// writes dropped in asynchronous mode.
+func (w *Writer) Stats() Stats {
+	var stats Stats
+	if w.archiver != nil {
+		w.archiver.stats.snapshot(&stats)
+	}
+	if w.async != nil {
+		w.async.snapshot(&stats)
+	}
+	return stats
+}
+
+// Sync writes out the buffer of the asynchronous mode and commits the
+// current log file to stable storage.
+func (w *Writer) Sync() error {
+	if err := w.flush(); err != nil {
+		return err
 	}
+	return w.rotator.sync()
+}
 
-	fi, err := file.Stat()
-	if err != nil {
-		return fmt.Errorf("can't stat logfile: %s", err)
+// Close closes the log file and stops background archiving,
+// it's equivalent to Shutdown with a background context.
+func (w *Writer) Close() error {
+	return w.Shutdown(context.Background())
+}
+
+// Shutdown writes out the buffer of the asynchronous mode, closes the log
+// file, stops the archiving goroutine and waits for an in-flight archive pass
+// to finish, or for ctx to be done. When the writer is created
+// WithArchiveOnShutdown, one last archive pass runs before returning.
+func (w *Writer) Shutdown(ctx context.Context) error {
+	var err error
+	if w.async != nil {
+		err = w.async.close()
+	}
+	if closeErr := w.rotator.shutdown(); err == nil {
+		err = closeErr
+	}
+
+	if w.archiver != nil {
+		if archiveErr := w.archiver.shutdown(ctx, w.opts.archiveOnShutdown); archiveErr != nil && err == nil {
+			err = archiveErr
+		}
 	}
 
-	w.file = file
-	w.fileSizeByte = fi.Size()
-	return nil
+	return err
 }
 
-func openFile(filename string) (*os.File, error) {
+func openFile(filename string, mode os.FileMode) (*os.File, error) {
 	dir := filepath.Dir(filename)
 
 	if _, err := os.Stat(dir); err != nil {
@@ -111,7 +265,7 @@ func openFile(filename string) (*os.File, error) {
 		}
 	}
 
-	return os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, os.ModePerm)
+	return os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, mode)
 }
 
 func prefixAndExt(filename string) (prefix, ext string) {
@@ -0,0 +1,199 @@
+package loggeradapter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewWriterConfigError(t *testing.T) {
+	dir := t.TempDir()
+
+	cases := []struct {
+		cfg   Config
+		field string
+	}{
+		{Config{Filename: filepath.Join(dir, "a.log"), Rotation: "1invalid"}, "Rotation"},
+		{Config{Filename: filepath.Join(dir, "a.log"), Rotation: "10"}, "Rotation"},
+		{Config{Filename: filepath.Join(dir, "a.log"), Backup: "10mb", Archive: "1d"}, "Backup"},
+		{Config{Filename: filepath.Join(dir, "a.log"), Backup: "1d", Archive: "2yday"}, "Archive"},
+		{Config{Filename: dir}, "Filename"},
+	}
+
+	for _, c := range cases {
+		w, err := NewWriter(c.cfg)
+		if w != nil {
+			t.Errorf("%+v: expected nil writer", c.cfg)
+		}
+
+		var cfgErr *ConfigError
+		if !errors.As(err, &cfgErr) {
+			t.Errorf("%+v: expected *ConfigError, got %v", c.cfg, err)
+			continue
+		}
+		if cfgErr.Field != c.field {
+			t.Errorf("%+v: expected field %s, got %s", c.cfg, c.field, cfgErr.Field)
+		}
+	}
+}
+
+func TestNewPanicsOnInvalidConfig(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected New to panic")
+		}
+	}()
+
+	New(Config{Filename: filepath.Join(t.TempDir(), "a.log"), Rotation: "1invalid"})
+}
+
+func TestNewWriterWithClock(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "app.log")
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+
+	w, err := NewWriter(Config{Filename: filename, Rotation: "10b"},
+		WithClock(ClockFunc(func() time.Time { return now })),
+		WithFileMode(0600))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = w.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+
+	backup := filepath.Join(dir, "app-"+now.Format(defaultTimeFormat)+".log")
+	if _, err = os.Stat(backup); err != nil {
+		t.Errorf("expected backup %s: %v", backup, err)
+	}
+
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %v", fi.Mode().Perm())
+	}
+}
+
+func TestWriterClose(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "app.log")
+
+	w, err := NewWriter(Config{Filename: filename})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = w.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+
+	if _, err = w.Write([]byte("late\n")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected os.ErrClosed, got %v", err)
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hello\n" {
+		t.Errorf("unexpected content %q", content)
+	}
+}
+
+func TestWriterShutdownArchives(t *testing.T) {
+	dir := t.TempDir()
+	var mu sync.Mutex
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+	clock := ClockFunc(func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(time.Second)
+		return now
+	})
+
+	w, err := NewWriter(Config{
+		Filename: filepath.Join(dir, "app.log"),
+		Rotation: "10b",
+		Backup:   "1",
+		Archive:  "5",
+	}, WithClock(clock), WithArchiveOnShutdown())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err = w.Write([]byte("0123456789")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = w.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, _ := filepath.Glob(filepath.Join(dir, "app-*.log"))
+	if len(backups) >= 3 {
+		t.Errorf("expected backups to be archived, got %v", backups)
+	}
+	archives, _ := filepath.Glob(filepath.Join(dir, "*"+defaultArchiveSuffix))
+	if len(archives) == 0 {
+		t.Error("expected at least one archive")
+	}
+}
+
+func TestWriterRotateAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "app.log")
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+
+	w, err := NewWriter(Config{Filename: filename},
+		WithClock(ClockFunc(func() time.Time { return now })))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if _, err = w.Write([]byte("first\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	backup := filepath.Join(dir, "app-"+now.Format(defaultTimeFormat)+".log")
+	if content, _ := os.ReadFile(backup); string(content) != "first\n" {
+		t.Errorf("unexpected backup content %q", content)
+	}
+
+	moved := filepath.Join(dir, "moved.log")
+	if err = os.Rename(filename, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte("second\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, _ := os.ReadFile(filename); string(content) != "second\n" {
+		t.Errorf("unexpected content %q", content)
+	}
+}